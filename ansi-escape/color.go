@@ -0,0 +1,38 @@
+package ansi
+
+import "sync/atomic"
+
+var noColor atomic.Bool
+
+// SetColor enables or disables the colors of the helpers below, which are
+// enabled by default.
+func SetColor(enabled bool) {
+	noColor.Store(!enabled)
+}
+
+func ColorEnabled() bool {
+	return !noColor.Load()
+}
+
+// Gray returns s in gray, or as it is if colors are disabled.
+func Gray(s string) string {
+	return paint(s, 90)
+}
+
+// Red returns s in red, or as it is if colors are disabled.
+func Red(s string) string {
+	return paint(s, 31)
+}
+
+// Yellow returns s in yellow, or as it is if colors are disabled.
+func Yellow(s string) string {
+	return paint(s, 33)
+}
+
+func paint(s string, params ...int) string {
+	if !ColorEnabled() {
+		return s
+	}
+
+	return Code(params...) + s + Reset
+}
@@ -0,0 +1,100 @@
+// Package ansi writes ANSI escape sequences, enabling them on terminals that
+// need to be told to take them.
+package ansi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Control sequences for the terminal, which are written as they are, whether
+// colors are enabled or not.
+const (
+	Reset       = "\033[m"
+	ClearScreen = "\033[2J"
+	MoveHome    = "\033[1;1H"
+	ClearLine   = "\033[2K\r"
+)
+
+// csi matches Control Sequence Introducer sequences, such as those of colors
+// and cursor movements.
+var csi = regexp.MustCompile("\033\\[[0-?]*[ -/]*[@-~]")
+
+// Code returns the Select Graphic Rendition sequence with the given
+// parameters, as Code(1, 33) for bold yellow. Without parameters, it is Reset.
+func Code(params ...int) string {
+	var b strings.Builder
+
+	b.WriteString("\033[")
+	for i, param := range params {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+
+		b.WriteString(strconv.Itoa(param))
+	}
+	b.WriteByte('m')
+
+	return b.String()
+}
+
+// Strip removes the control sequences from s.
+func Strip(s string) string {
+	return csi.ReplaceAllString(s, "")
+}
+
+// Wrap breaks the lines of s so that none is wider than width columns, between
+// words where possible, disregarding the control sequences in it, which are
+// never broken. Words wider than a line are broken wherever needed. A width of
+// zero or less leaves s as it is.
+func Wrap(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = wrapLine(line, width)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func wrapLine(line string, width int) string {
+	var b strings.Builder
+	col := 0
+
+	for i, word := range strings.Split(line, " ") {
+		switch n := utf8.RuneCountInString(Strip(word)); {
+		case i == 0:
+		case col+1+n <= width:
+			b.WriteByte(' ')
+			col++
+		default:
+			b.WriteByte('\n')
+			col = 0
+		}
+
+		for word != "" {
+			if loc := csi.FindStringIndex(word); loc != nil && loc[0] == 0 {
+				b.WriteString(word[:loc[1]])
+				word = word[loc[1]:]
+				continue
+			}
+
+			if col == width {
+				b.WriteByte('\n')
+				col = 0
+			}
+
+			_, size := utf8.DecodeRuneInString(word)
+			b.WriteString(word[:size])
+			word = word[size:]
+			col++
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,39 @@
+package ansi
+
+import "testing"
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		params []int
+		want   string
+	}{
+		{nil, Reset},
+		{[]int{90}, "\033[90m"},
+		{[]int{1, 33}, "\033[1;33m"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(tt.params...); got != tt.want {
+			t.Errorf("Code(%v) = %q, want %q", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"plain text", "plain text"},
+		{Code(31) + "error" + Reset + ": failed", "error: failed"},
+		{ClearScreen + MoveHome + "[12:00] First execution", "[12:00] First execution"},
+		{"50%" + ClearLine + "100%\n", "50%\r100%\n"},
+		{"\033[1;90mdone\033[0m in \033[3A1s\033[?25l", "done in 1s"},
+		{"[brackets] are kept", "[brackets] are kept"},
+	}
+
+	for _, tt := range tests {
+		if got := Strip(tt.s); got != tt.want {
+			t.Errorf("Strip(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !aix && !linux && !solaris && !zos && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package ansi
+
+import "errors"
+
+var (
+	errRawUnsupported  = errors.New("raw mode is not supported on this platform")
+	errSizeUnsupported = errors.New("the terminal size cannot be told on this platform")
+)
+
+// State is the state of a terminal, which is never changed on this platform.
+type State struct{}
+
+// IsTerminal reports whether fd refers to a terminal, which is never known to
+// be the case on this platform.
+func IsTerminal(_ uintptr) bool {
+	return false
+}
+
+// TerminalSize fails, as the size cannot be told on this platform.
+func TerminalSize(_ uintptr) (cols, rows int, err error) {
+	return 0, 0, errSizeUnsupported
+}
+
+// MakeRaw fails, as raw mode is not supported on this platform.
+func MakeRaw(_ uintptr) (*State, error) {
+	return nil, errRawUnsupported
+}
+
+// NoNewlineTranslation does nothing, as there are no terminals to be changed
+// on this platform.
+func NoNewlineTranslation(_ uintptr) error {
+	return nil
+}
+
+// Restore does nothing, see MakeRaw.
+func Restore(_ uintptr, _ *State) error {
+	return nil
+}
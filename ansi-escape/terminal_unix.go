@@ -0,0 +1,10 @@
+//go:build aix || linux || solaris || zos
+
+package ansi
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
@@ -0,0 +1,54 @@
+//go:build windows
+
+package ansi
+
+import "golang.org/x/sys/windows"
+
+// State is the state of a console, as it was before MakeRaw.
+type State struct {
+	mode uint32
+}
+
+// IsTerminal reports whether fd refers to a console.
+func IsTerminal(fd uintptr) bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(fd), &mode) == nil
+}
+
+// TerminalSize returns the number of columns and rows of the window of the
+// console fd refers to.
+func TerminalSize(fd uintptr) (cols, rows int, err error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(fd), &info); err != nil {
+		return 0, 0, err
+	}
+
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
+
+// MakeRaw makes the console fd refers to hand over every key as soon as it is
+// pressed, without echoing it. Ctrl+C is still processed by the system. The
+// state returned is the one to be restored with Restore.
+func MakeRaw(fd uintptr) (*State, error) {
+	var mode uint32
+	if err := windows.GetConsoleMode(windows.Handle(fd), &mode); err != nil {
+		return nil, err
+	}
+
+	raw := mode &^ (windows.ENABLE_ECHO_INPUT | windows.ENABLE_LINE_INPUT)
+	if err := windows.SetConsoleMode(windows.Handle(fd), raw); err != nil {
+		return nil, err
+	}
+
+	return &State{mode: mode}, nil
+}
+
+// NoNewlineTranslation does nothing, as it is not supported for consoles.
+func NoNewlineTranslation(_ uintptr) error {
+	return nil
+}
+
+// Restore puts the console fd refers to back in the given state.
+func Restore(fd uintptr, state *State) error {
+	return windows.SetConsoleMode(windows.Handle(fd), state.mode)
+}
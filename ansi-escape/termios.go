@@ -0,0 +1,67 @@
+//go:build aix || linux || solaris || zos || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package ansi
+
+import "golang.org/x/sys/unix"
+
+// State is the state of a terminal, as it was before MakeRaw.
+type State struct {
+	termios unix.Termios
+}
+
+// IsTerminal reports whether fd refers to a terminal.
+func IsTerminal(fd uintptr) bool {
+	_, err := unix.IoctlGetTermios(int(fd), ioctlGetTermios)
+	return err == nil
+}
+
+// TerminalSize returns the number of columns and rows of the terminal fd
+// refers to.
+func TerminalSize(fd uintptr) (cols, rows int, err error) {
+	ws, err := unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// MakeRaw makes the terminal fd refers to hand over every key as soon as it is
+// pressed, without echoing it. Keys that send signals, such as Ctrl+C, still
+// do so. The state returned is the one to be restored with Restore.
+func MakeRaw(fd uintptr) (*State, error) {
+	termios, err := unix.IoctlGetTermios(int(fd), ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	old := &State{termios: *termios}
+
+	termios.Lflag &^= unix.ICANON | unix.ECHO
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(int(fd), ioctlSetTermios, termios); err != nil {
+		return nil, err
+	}
+
+	return old, nil
+}
+
+// NoNewlineTranslation makes the terminal fd refers to output newlines as they
+// are written, rather than as a carriage return followed by a newline, as is
+// fit for output that is copied to somewhere else.
+func NoNewlineTranslation(fd uintptr) error {
+	termios, err := unix.IoctlGetTermios(int(fd), ioctlGetTermios)
+	if err != nil {
+		return err
+	}
+
+	termios.Oflag &^= unix.ONLCR
+	return unix.IoctlSetTermios(int(fd), ioctlSetTermios, termios)
+}
+
+// Restore puts the terminal fd refers to back in the given state.
+func Restore(fd uintptr, state *State) error {
+	return unix.IoctlSetTermios(int(fd), ioctlSetTermios, &state.termios)
+}
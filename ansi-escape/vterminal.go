@@ -2,10 +2,13 @@
 
 package ansi
 
+// EnableVirtualTerminal does nothing, as terminals other than the Windows
+// console take escape sequences as they are.
 func EnableVirtualTerminal(_ uintptr) error {
 	return nil
 }
 
+// DisableVirtualTerminal does nothing, see EnableVirtualTerminal.
 func DisableVirtualTerminal(_ uintptr) error {
 	return nil
 }
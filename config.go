@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alan-b-lima/watcher/watcher"
+)
+
+// defaultConfig is the configuration file read when none is given, if it
+// exists.
+const defaultConfig = "watcher.json"
+
+// config mirrors the flags, durations being in milliseconds. Relative paths
+// are taken from the directory the configuration file is in.
+type config struct {
+	Watch          []string   `json:"watch"`
+	Ignore         []string   `json:"ignore"`
+	IgnoreFiles    []string   `json:"ignore_files"`
+	IgnoreHidden   bool       `json:"ignore_hidden"`
+	Git            bool       `json:"git"`
+	IgnoreSelf     bool       `json:"ignore_self"`
+	SkipErrors     bool       `json:"skip_errors"`
+	Extensions     []string   `json:"ext"`
+	Include        []string   `json:"include"`
+	Exec           [][]string `json:"exec"`
+	OnFail         string     `json:"on_fail"`
+	OnSuccess      string     `json:"on_success"`
+	TickSpeed      int64      `json:"tick_speed"`
+	Debounce       int64      `json:"debounce"`
+	BatchWindow    int64      `json:"batch_window"`
+	Throttle       int64      `json:"throttle"`
+	StartDelay     int64      `json:"start_delay"`
+	Preview        bool       `json:"preview"`
+	Timeout        int64      `json:"timeout"`
+	Retry          int        `json:"retry"`
+	FailFast       bool       `json:"fail_fast"`
+	Count          int        `json:"count"`
+	CountInitial   bool       `json:"count_initial"`
+	MaxDepth       *int       `json:"max_depth"`
+	Restart        bool       `json:"restart"`
+	Notify         bool       `json:"notify"`
+	NoPollFallback bool       `json:"no_poll_fallback"`
+	FollowSymlinks bool       `json:"follow_symlinks"`
+	AllowMissing   bool       `json:"allow_missing"`
+	Hash           bool       `json:"hash"`
+	FastScan       bool       `json:"fast_scan"`
+	TriggerStdin   bool       `json:"trigger_stdin"`
+	Interactive    bool       `json:"interactive"`
+	NoClear        bool       `json:"no_clear"`
+	KeepOnFailure  bool       `json:"keep_on_failure"`
+	JSON           bool       `json:"json"`
+	JSONCapture    bool       `json:"json_capture"`
+	RequireChange  bool       `json:"require_change"`
+	NoInitial      bool       `json:"no_initial"`
+	Once           bool       `json:"once"`
+	Cwd            string     `json:"cwd"`
+	Root           string     `json:"root"`
+	Tail           string     `json:"tail"`
+	BannerFormat   string     `json:"banner_format"`
+	StateFile      string     `json:"state_file"`
+	Env            []string   `json:"env"`
+	Shell          string     `json:"shell"`
+	NoShell        bool       `json:"no_shell"`
+	DryRun         bool       `json:"dry_run"`
+	Summary        bool       `json:"summary"`
+	MergeStderr    bool       `json:"merge_stderr"`
+	QuietStderr    bool       `json:"quiet_stderr"`
+	CwdChanged     bool       `json:"cwd_changed"`
+	PTY            bool       `json:"pty"`
+	Verbose        bool       `json:"verbose"`
+	Quiet          bool       `json:"quiet"`
+	LogLevel       string     `json:"log_level"`
+	NoColor        bool       `json:"no_color"`
+
+	NoHeartbeat       bool  `json:"no_heartbeat"`
+	HeartbeatInterval int64 `json:"heartbeat_interval"`
+
+	IgnoreLargerThan int64 `json:"ignore_larger_than"`
+	IgnoreOlderThan  int64 `json:"ignore_older_than"`
+
+	// Name and Tasks are for tasks, which take the fields they leave unset
+	// from the configuration they are in. Each of them is run by a watcher of
+	// its own.
+	Name  string   `json:"name,omitempty"`
+	Tasks []config `json:"tasks,omitempty"`
+}
+
+// loadConfig reads the configuration file given, or the default one if it
+// exists, into a flagState.
+func loadConfig(name string) (flagState, error) {
+	explicit := name != ""
+	if !explicit {
+		name = defaultConfig
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return flagState{}, nil
+		}
+
+		return flagState{}, err
+	}
+
+	var cfg config
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return flagState{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	fls, err := cfg.flagState(filepath.Dir(name))
+	if err != nil {
+		return flagState{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	names := make(map[string]bool, len(cfg.Tasks))
+	for _, task := range cfg.Tasks {
+		switch {
+		case task.Name == "":
+			return flagState{}, fmt.Errorf("%s: %w", name, errUnnamedTask)
+		case names[task.Name]:
+			return flagState{}, fmt.Errorf("%s: %w", name, errDuplicateTask(task.Name))
+		case len(task.Tasks) != 0:
+			return flagState{}, fmt.Errorf("%s: %w", name, errNestedTasks(task.Name))
+		}
+		names[task.Name] = true
+
+		tfls, err := task.flagState(filepath.Dir(name))
+		if err != nil {
+			return flagState{}, fmt.Errorf("%s: task %s: %w", name, task.Name, err)
+		}
+
+		fls.tasks = append(fls.tasks, tfls)
+	}
+
+	return fls, nil
+}
+
+// flagState converts the configuration into a flagState, taking relative paths
+// from dir. The tasks are left to the caller.
+func (cfg config) flagState(dir string) (flagState, error) {
+	var fls flagState
+
+	for _, path := range cfg.Watch {
+		fls.Watch = append(fls.Watch, relativeTo(dir, path))
+	}
+
+	for _, path := range cfg.IgnoreFiles {
+		fls.IgnoreFiles = append(fls.IgnoreFiles, relativeTo(dir, path))
+	}
+
+	if cfg.Cwd != "" {
+		fls.Dir = relativeTo(dir, cfg.Cwd)
+	}
+
+	if cfg.Root != "" {
+		fls.Root = relativeTo(dir, cfg.Root)
+	}
+
+	if cfg.Tail != "" {
+		fls.Tail = relativeTo(dir, cfg.Tail)
+	}
+
+	if cfg.StateFile != "" {
+		fls.StateFile = relativeTo(dir, cfg.StateFile)
+	}
+
+	for _, env := range cfg.Env {
+		if err := validateEnv(env); err != nil {
+			return flagState{}, err
+		}
+	}
+
+	for _, cmd := range cfg.Exec {
+		if err := validateCommand(cmd); err != nil {
+			return flagState{}, err
+		}
+	}
+
+	for _, hook := range []string{cfg.OnFail, cfg.OnSuccess} {
+		if hook == "" {
+			continue
+		}
+
+		if err := validateCommand([]string{hook}); err != nil {
+			return flagState{}, err
+		}
+	}
+
+	if cfg.Retry < 0 {
+		return flagState{}, errNonPositive("retry")
+	}
+
+	if cfg.Count < 0 {
+		return flagState{}, errNonPositive("count")
+	}
+
+	if cfg.IgnoreLargerThan < 0 {
+		return flagState{}, errNonPositive("ignore_larger_than")
+	}
+
+	if cfg.MaxDepth != nil {
+		if *cfg.MaxDepth < 0 {
+			return flagState{}, errNegative("max_depth")
+		}
+
+		fls.MaxDepth = *cfg.MaxDepth + 1
+	}
+
+	fls.Env = cfg.Env
+	fls.Ignore = cfg.Ignore
+	fls.Retry = cfg.Retry
+	fls.Count = cfg.Count
+	fls.IgnoreLargerThan = cfg.IgnoreLargerThan
+	fls.Extensions = cfg.Extensions
+	fls.Include = cfg.Include
+	fls.Exec = cfg.Exec
+	fls.OnFail = cfg.OnFail
+	fls.OnSuccess = cfg.OnSuccess
+
+	durations := []struct {
+		field string
+		ms    int64
+		dst   *time.Duration
+	}{
+		{"tick_speed", cfg.TickSpeed, &fls.TickSpeed},
+		{"debounce", cfg.Debounce, &fls.Debounce},
+		{"batch_window", cfg.BatchWindow, &fls.BatchWindow},
+		{"throttle", cfg.Throttle, &fls.Throttle},
+		{"start_delay", cfg.StartDelay, &fls.StartDelay},
+		{"ignore_older_than", cfg.IgnoreOlderThan, &fls.IgnoreOlderThan},
+		{"timeout", cfg.Timeout, &fls.Timeout},
+		{"heartbeat_interval", cfg.HeartbeatInterval, &fls.HeartbeatInterval},
+	}
+
+	for _, d := range durations {
+		if d.ms < 0 {
+			return flagState{}, errNonPositive(d.field)
+		}
+
+		*d.dst = time.Duration(d.ms) * time.Millisecond
+	}
+
+	fls.IgnoreHidden = cfg.IgnoreHidden
+	fls.Git = cfg.Git
+	fls.IgnoreSelf = cfg.IgnoreSelf
+	fls.SkipErrors = cfg.SkipErrors
+	fls.FailFast = cfg.FailFast
+	fls.Restart = cfg.Restart
+	fls.Notify = cfg.Notify
+	fls.Preview = cfg.Preview
+	fls.NoPollFallback = cfg.NoPollFallback
+	fls.FollowSymlinks = cfg.FollowSymlinks
+	fls.AllowMissing = cfg.AllowMissing
+	fls.Hash = cfg.Hash
+	fls.FastScan = cfg.FastScan
+	fls.TriggerStdin = cfg.TriggerStdin
+	fls.Interactive = cfg.Interactive
+	fls.NoClear = cfg.NoClear
+	fls.KeepOnFailure = cfg.KeepOnFailure
+	fls.JSON = cfg.JSON || cfg.JSONCapture
+	fls.JSONCapture = cfg.JSONCapture
+	fls.RequireChange = cfg.RequireChange
+	fls.NoInitial = cfg.NoInitial
+	fls.Once = cfg.Once
+	fls.CountInitial = cfg.CountInitial
+	fls.Shell = cfg.Shell
+	fls.NoShell = cfg.NoShell
+	fls.DryRun = cfg.DryRun
+	fls.Summary = cfg.Summary
+	fls.BannerFormat = cfg.BannerFormat
+	fls.MergeStderr = cfg.MergeStderr
+	fls.QuietStderr = cfg.QuietStderr
+	fls.CwdChanged = cfg.CwdChanged
+	fls.PTY = cfg.PTY
+	fls.Verbose = cfg.Verbose
+	fls.Quiet = cfg.Quiet
+
+	if cfg.LogLevel != "" {
+		level, err := parseLogLevel(cfg.LogLevel)
+		if err != nil {
+			return flagState{}, err
+		}
+
+		fls.LogLevel, fls.logLevelSet = level, true
+	}
+
+	fls.Name = cfg.Name
+	fls.noColor = cfg.NoColor
+	fls.NoHeartbeat = cfg.NoHeartbeat
+
+	return fls, nil
+}
+
+// configOf converts the options back into a configuration, the way it would
+// be written in a configuration file.
+func configOf(fls flagState) config {
+	ms := func(d time.Duration) int64 { return d.Milliseconds() }
+
+	cfg := config{
+		Name:              fls.Name,
+		Watch:             fls.Watch,
+		Ignore:            fls.Ignore,
+		IgnoreFiles:       fls.IgnoreFiles,
+		IgnoreHidden:      fls.IgnoreHidden,
+		Git:               fls.Git,
+		IgnoreSelf:        fls.IgnoreSelf,
+		SkipErrors:        fls.SkipErrors,
+		Extensions:        fls.Extensions,
+		Include:           fls.Include,
+		Exec:              fls.Exec,
+		OnFail:            fls.OnFail,
+		OnSuccess:         fls.OnSuccess,
+		TickSpeed:         ms(fls.TickSpeed),
+		Debounce:          ms(fls.Debounce),
+		BatchWindow:       ms(fls.BatchWindow),
+		Throttle:          ms(fls.Throttle),
+		StartDelay:        ms(fls.StartDelay),
+		IgnoreLargerThan:  fls.IgnoreLargerThan,
+		IgnoreOlderThan:   ms(fls.IgnoreOlderThan),
+		Preview:           fls.Preview,
+		Timeout:           ms(fls.Timeout),
+		Retry:             fls.Retry,
+		FailFast:          fls.FailFast,
+		Count:             fls.Count,
+		CountInitial:      fls.CountInitial,
+		Restart:           fls.Restart,
+		Notify:            fls.Notify,
+		NoPollFallback:    fls.NoPollFallback,
+		FollowSymlinks:    fls.FollowSymlinks,
+		AllowMissing:      fls.AllowMissing,
+		Hash:              fls.Hash,
+		FastScan:          fls.FastScan,
+		TriggerStdin:      fls.TriggerStdin,
+		Interactive:       fls.Interactive,
+		NoClear:           fls.NoClear,
+		KeepOnFailure:     fls.KeepOnFailure,
+		JSON:              fls.JSON,
+		JSONCapture:       fls.JSONCapture,
+		RequireChange:     fls.RequireChange,
+		NoInitial:         fls.NoInitial,
+		Once:              fls.Once,
+		Cwd:               fls.Dir,
+		Root:              fls.Root,
+		Tail:              fls.Tail,
+		BannerFormat:      fls.BannerFormat,
+		StateFile:         fls.StateFile,
+		Env:               fls.Env,
+		Shell:             fls.Shell,
+		NoShell:           fls.NoShell,
+		DryRun:            fls.DryRun,
+		Summary:           fls.Summary,
+		MergeStderr:       fls.MergeStderr,
+		QuietStderr:       fls.QuietStderr,
+		CwdChanged:        fls.CwdChanged,
+		PTY:               fls.PTY,
+		Verbose:           fls.Verbose,
+		Quiet:             fls.Quiet,
+		LogLevel:          fls.LogLevel.String(),
+		NoColor:           fls.noColor,
+		NoHeartbeat:       fls.NoHeartbeat,
+		HeartbeatInterval: ms(fls.HeartbeatInterval),
+	}
+
+	// the depth is counted from the entries in the roots in the file
+	if fls.MaxDepth != 0 {
+		depth := fls.MaxDepth - 1
+		cfg.MaxDepth = &depth
+	}
+
+	return cfg
+}
+
+// merge overrides the configuration with the flags given, which take
+// precedence whenever they are set.
+func merge(cfg, fls flagState) flagState {
+	override(&cfg.Watch, fls.Watch)
+	override(&cfg.Ignore, fls.Ignore)
+	override(&cfg.IgnoreFiles, fls.IgnoreFiles)
+	override(&cfg.Extensions, fls.Extensions)
+	override(&cfg.Include, fls.Include)
+	override(&cfg.Env, fls.Env)
+	override(&cfg.Exec, fls.Exec)
+
+	for _, d := range []struct{ dst, src *time.Duration }{
+		{&cfg.TickSpeed, &fls.TickSpeed},
+		{&cfg.Debounce, &fls.Debounce},
+		{&cfg.BatchWindow, &fls.BatchWindow},
+		{&cfg.Throttle, &fls.Throttle},
+		{&cfg.StartDelay, &fls.StartDelay},
+		{&cfg.IgnoreOlderThan, &fls.IgnoreOlderThan},
+		{&cfg.Timeout, &fls.Timeout},
+		{&cfg.HeartbeatInterval, &fls.HeartbeatInterval},
+	} {
+		if *d.src != 0 {
+			*d.dst = *d.src
+		}
+	}
+
+	if fls.logLevelSet {
+		cfg.LogLevel, cfg.logLevelSet = fls.LogLevel, true
+	}
+
+	if fls.Retry != 0 {
+		cfg.Retry = fls.Retry
+	}
+
+	if fls.Count != 0 {
+		cfg.Count = fls.Count
+	}
+
+	if fls.IgnoreLargerThan != 0 {
+		cfg.IgnoreLargerThan = fls.IgnoreLargerThan
+	}
+
+	if fls.MaxDepth != 0 {
+		cfg.MaxDepth = fls.MaxDepth
+	}
+
+	if fls.Dir != "" {
+		cfg.Dir = fls.Dir
+	}
+
+	if fls.Name != "" {
+		cfg.Name = fls.Name
+	}
+
+	if fls.Root != "" {
+		cfg.Root = fls.Root
+	}
+
+	if fls.Tail != "" {
+		cfg.Tail = fls.Tail
+	}
+
+	if fls.BannerFormat != "" {
+		cfg.BannerFormat = fls.BannerFormat
+	}
+
+	if fls.StateFile != "" {
+		cfg.StateFile = fls.StateFile
+	}
+
+	if fls.OnFail != "" {
+		cfg.OnFail = fls.OnFail
+	}
+
+	if fls.OnSuccess != "" {
+		cfg.OnSuccess = fls.OnSuccess
+	}
+
+	if fls.Shell != "" || fls.NoShell {
+		cfg.Shell, cfg.NoShell = fls.Shell, fls.NoShell
+	}
+
+	cfg.IgnoreHidden = cfg.IgnoreHidden || fls.IgnoreHidden
+	cfg.Git = cfg.Git || fls.Git
+	cfg.IgnoreSelf = cfg.IgnoreSelf || fls.IgnoreSelf
+	cfg.SkipErrors = cfg.SkipErrors || fls.SkipErrors
+	cfg.FailFast = cfg.FailFast || fls.FailFast
+	cfg.Restart = cfg.Restart || fls.Restart
+	cfg.Notify = cfg.Notify || fls.Notify
+	cfg.Preview = cfg.Preview || fls.Preview
+	cfg.NoPollFallback = cfg.NoPollFallback || fls.NoPollFallback
+	cfg.FollowSymlinks = cfg.FollowSymlinks || fls.FollowSymlinks
+	cfg.AllowMissing = cfg.AllowMissing || fls.AllowMissing
+	cfg.Hash = cfg.Hash || fls.Hash
+	cfg.FastScan = cfg.FastScan || fls.FastScan
+	cfg.TriggerStdin = cfg.TriggerStdin || fls.TriggerStdin
+	cfg.Interactive = cfg.Interactive || fls.Interactive
+	cfg.NoClear = cfg.NoClear || fls.NoClear
+	cfg.KeepOnFailure = cfg.KeepOnFailure || fls.KeepOnFailure
+	cfg.JSON = cfg.JSON || fls.JSON
+	cfg.JSONCapture = cfg.JSONCapture || fls.JSONCapture
+	cfg.RequireChange = cfg.RequireChange || fls.RequireChange
+	cfg.NoInitial = cfg.NoInitial || fls.NoInitial
+	cfg.Once = cfg.Once || fls.Once
+	cfg.CountInitial = cfg.CountInitial || fls.CountInitial
+	cfg.DryRun = cfg.DryRun || fls.DryRun
+	cfg.Summary = cfg.Summary || fls.Summary
+	cfg.MergeStderr = cfg.MergeStderr || fls.MergeStderr
+	cfg.QuietStderr = cfg.QuietStderr || fls.QuietStderr
+	cfg.CwdChanged = cfg.CwdChanged || fls.CwdChanged
+	cfg.PTY = cfg.PTY || fls.PTY
+	cfg.Verbose = cfg.Verbose || fls.Verbose
+	cfg.Quiet = cfg.Quiet || fls.Quiet
+	cfg.noColor = cfg.noColor || fls.noColor
+	cfg.list = fls.list
+	cfg.print0 = fls.print0
+	cfg.printConfig = fls.printConfig
+	cfg.reloadConfig = fls.reloadConfig
+	cfg.NoHeartbeat = cfg.NoHeartbeat || fls.NoHeartbeat
+
+	return cfg
+}
+
+func override[T any](dst *[]T, src []T) {
+	if len(src) != 0 {
+		*dst = src
+	}
+}
+
+func relativeTo(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// reloadDebounce is for how long the configuration file must be left as it is
+// before it is reloaded, so that the bursts of writes editors make on saving
+// result in a single reload.
+const reloadDebounce = 500 * time.Millisecond
+
+// watchConfig signals once the configuration file at name has changed and has
+// been left as it is for reloadDebounce, until ctx is done. Its removal is a
+// change too, as is its coming back.
+func watchConfig(ctx context.Context, name string) <-chan struct{} {
+	reloads := make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(watcher.Granularity)
+		defer ticker.Stop()
+
+		last, _ := os.Stat(name)
+
+		var changed time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, _ := os.Stat(name)
+			if !sameConfig(last, info) {
+				last, changed = info, time.Now()
+				continue
+			}
+
+			if changed.IsZero() || time.Since(changed) < reloadDebounce {
+				continue
+			}
+
+			changed = time.Time{}
+			select {
+			case reloads <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return reloads
+}
+
+// sameConfig reports whether the configuration file is as it was, nil standing
+// for it not existing.
+func sameConfig(a, b fs.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
@@ -2,4 +2,8 @@ module github.com/alan-b-lima/watcher
 
 go 1.24.5
 
-require golang.org/x/sys v0.34.0
+require (
+	github.com/creack/pty v1.1.24
+	github.com/fsnotify/fsnotify v1.10.1
+	golang.org/x/sys v0.34.0
+)
@@ -0,0 +1,1407 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/alan-b-lima/watcher/ansi-escape"
+	"github.com/alan-b-lima/watcher/watcher"
+)
+
+const Version = "v0.0.3"
+
+const (
+	exitSuccess       = 0
+	exitFailure       = 1
+	exitUnsupportedOS = 2
+)
+
+const (
+	flagWatch = iota
+	flagIgnore
+	flagExec
+	flagTickSpeed
+	flagDebounce
+	flagIgnoreFile
+	flagShell
+	flagTimeout
+	flagConfig
+	flagHeartbeatInterval
+	flagExt
+	flagRetry
+	flagCount
+	flagMaxDepth
+	flagInclude
+	flagLogLevel
+	flagBatchWindow
+	flagThrottle
+	flagStartDelay
+	flagIgnoreLargerThan
+	flagIgnoreOlderThan
+	flagCwd
+	flagRoot
+	flagTail
+	flagBannerFormat
+	flagStateFile
+	flagEnv
+	flagOnFail
+	flagOnSuccess
+	flagAfterValue
+	flagRestart
+	flagNotify
+	flagNoClear
+	flagJSON
+	flagJSONCapture
+	flagRequireChange
+	flagNoInitial
+	flagNoShell
+	flagDryRun
+	flagVerbose
+	flagQuiet
+	flagOnce
+	flagFollowSymlinks
+	flagNoHeartbeat
+	flagAllowMissing
+	flagTriggerStdin
+	flagNoColor
+	flagInteractive
+	flagHash
+	flagList
+	flagIgnoreHidden
+	flagGit
+	flagSkipErrors
+	flagFastScan
+	flagNoPollFallback
+	flagPreview
+	flagPrint0
+	flagIgnoreSelf
+	flagKeepOnFailure
+	flagCountInitial
+	flagPrintConfig
+	flagFailFast
+	flagSummary
+	flagMergeStderr
+	flagQuietStderr
+	flagCwdChanged
+	flagReloadConfig
+	flagPTY
+)
+
+var flags = map[string]int{
+	"-w": flagWatch, "--watch": flagWatch,
+	"-i": flagIgnore, "--ignore": flagIgnore,
+	"-e": flagExec, "--exec": flagExec, "--": flagExec,
+	"-t": flagTickSpeed, "--tick-speed": flagTickSpeed, "--poll-interval": flagTickSpeed,
+	"-r": flagRestart, "--restart": flagRestart,
+	"-n": flagNotify, "--notify": flagNotify,
+	"-q": flagQuiet, "--quiet": flagQuiet,
+	"--no-clear":       flagNoClear,
+	"--json":           flagJSON,
+	"--json-capture":   flagJSONCapture,
+	"--require-change": flagRequireChange,
+	"--no-initial":     flagNoInitial, "--on-start=false": flagNoInitial,
+	"--debounce":    flagDebounce,
+	"--ignore-file": flagIgnoreFile,
+	"--shell":       flagShell,
+	"--timeout":     flagTimeout,
+	"--config":      flagConfig,
+	"--no-shell":    flagNoShell,
+	"--dry-run":     flagDryRun,
+	"--verbose":     flagVerbose,
+	"--once":        flagOnce,
+
+	"--follow-symlinks":    flagFollowSymlinks,
+	"--no-heartbeat":       flagNoHeartbeat,
+	"--heartbeat-interval": flagHeartbeatInterval,
+	"--allow-missing":      flagAllowMissing,
+	"--ext":                flagExt,
+	"--trigger-stdin":      flagTriggerStdin,
+	"--retry":              flagRetry,
+	"--count":              flagCount,
+	"--count-initial":      flagCountInitial,
+	"--max-depth":          flagMaxDepth,
+	"--include":            flagInclude,
+	"--log-level":          flagLogLevel,
+	"--batch-window":       flagBatchWindow,
+	"--throttle":           flagThrottle,
+	"--start-delay":        flagStartDelay,
+	"--ignore-larger-than": flagIgnoreLargerThan,
+	"--ignore-older-than":  flagIgnoreOlderThan,
+	"--cwd":                flagCwd,
+	"--root":               flagRoot,
+	"--tail":               flagTail,
+	"--banner-format":      flagBannerFormat,
+	"--state-file":         flagStateFile,
+	"--env":                flagEnv,
+	"--on-fail":            flagOnFail,
+	"--on-success":         flagOnSuccess,
+	"--list":               flagList,
+	"--ignore-hidden":      flagIgnoreHidden,
+	"--git":                flagGit,
+	"--skip-errors":        flagSkipErrors,
+	"--no-color":           flagNoColor,
+	"--interactive":        flagInteractive,
+	"--hash":               flagHash,
+	"--fast-scan":          flagFastScan,
+	"--no-poll-fallback":   flagNoPollFallback,
+	"--preview":            flagPreview,
+	"--print0":             flagPrint0,
+	"--ignore-self":        flagIgnoreSelf,
+	"--keep-on-failure":    flagKeepOnFailure,
+	"--print-config":       flagPrintConfig,
+	"--fail-fast":          flagFailFast,
+	"--summary":            flagSummary,
+	"--merge-stderr":       flagMergeStderr,
+	"--quiet-stderr":       flagQuietStderr,
+	"--cwd-changed":        flagCwdChanged,
+	"--reload-config":      flagReloadConfig,
+	"--pty":                flagPTY,
+}
+
+var (
+	errNoExecFlag            = errors.New("no execution flag has been found, nor commands in the configuration file")
+	errBlankCommand          = errors.New("the command to be executed is blank, which may be a quoting mistake")
+	errUnknownFlag           = errors.New("unknown flag")
+	errEmptyCommand          = errors.New("the command to be executed is empty")
+	errArgAfterValueFlag     = func(flag string) error { return fmt.Errorf("only one argument should be passed after %s", flag) }
+	errFailedToParseDuration = errors.New("given duration failed to be parsed, as in 1500, in milliseconds, or 1.5s")
+	errFailedToParseNumber   = errors.New("given value failed to be parsed as a number")
+	errFailedToParseSize     = errors.New("given size failed to be parsed, as in 1048576, in bytes, or 1M")
+	errNonPositive           = func(flag string) error { return fmt.Errorf("the value of %s must be positive", flag) }
+	errNegative              = func(flag string) error { return fmt.Errorf("the value of %s must not be negative", flag) }
+	errNotInteractive        = errors.New("--interactive requires the standard input to be a terminal")
+	errAlreadySet            = func(flag string) error { return fmt.Errorf("%s has already been set", flag) }
+	errUnnamedTask           = errors.New("every task must have a name")
+	errDuplicateTask         = func(task string) error { return fmt.Errorf("there is more than one task named %s", task) }
+	errNestedTasks           = func(task string) error { return fmt.Errorf("task %s cannot have tasks of its own", task) }
+	errStdinTasks            = errors.New("the standard input cannot be read by more than one task")
+	errReloadStdin           = errors.New("the standard input cannot be read with --reload-config")
+	errSharedStateFile       = func(path string) error {
+		return fmt.Errorf("the state file %s cannot be shared by more than one task", path)
+	}
+	errMalformedEnv    = func(env string) error { return fmt.Errorf("%q is not of the KEY=VALUE form", env) }
+	errUnknownLogLevel = func(level string) error {
+		return fmt.Errorf("unknown log level %q, expected one of debug, info, warn or error", level)
+	}
+	errMissingValue = func(flag string, i int) error {
+		return fmt.Errorf("missing value for %s (argument %d)", flag, i+1)
+	}
+	errNoConfigToReload = func(name string) error {
+		return fmt.Errorf("--reload-config requires a configuration file, and %s cannot be read", name)
+	}
+)
+
+type flagState struct {
+	watcher.Options
+
+	config      string
+	noColor     bool
+	list        bool
+	print0      bool
+	printConfig bool
+
+	// reloadConfig restarts the watchers with the configuration file once it
+	// has changed
+	reloadConfig bool
+
+	// logLevelSet tells whether the log level has been given, as the default
+	// level can also be given explicitly
+	logLevelSet bool
+
+	// tasks are read from the configuration file, each of them being run by a
+	// watcher of its own
+	tasks []flagState
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the application and returns its exit code, so that the deferred
+// calls are done with by the time it exits.
+func run() int {
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "--help", "-h":
+			help()
+			return exitSuccess
+
+		case "--version", "-v":
+			short := len(os.Args) >= 3 && os.Args[2] == "--short"
+			version(short || !ansi.IsTerminal(os.Stdout.Fd()))
+			return exitSuccess
+		}
+	}
+
+	fls, err := processFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	cfg, tasks, err := loadTasks(fls)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	cli := fls
+	fls = merge(cfg, fls)
+	if fls.list {
+		return list(tasks, fls.print0)
+	}
+
+	if fls.printConfig {
+		return printConfig(tasks)
+	}
+
+	if err := checkTasks(tasks, fls.reloadConfig); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	configName := cmp.Or(fls.config, defaultConfig)
+	if fls.reloadConfig {
+		if _, err := os.Stat(configName); err != nil {
+			fmt.Fprintln(os.Stderr, errNoConfigToReload(configName))
+			return exitFailure
+		}
+	}
+
+	// the escape sequences of the watcher go to the standard error, leaving the
+	// standard output to the commands, and they are only written to terminals
+	// that take them
+	terminal := ansi.IsTerminal(os.Stderr.Fd())
+	if terminal {
+		if err := ansi.EnableVirtualTerminal(os.Stderr.Fd()); err != nil {
+			terminal = false
+		} else {
+			defer ansi.DisableVirtualTerminal(os.Stderr.Fd())
+		}
+	}
+
+	ansi.SetColor(terminal && !fls.noColor && os.Getenv("NO_COLOR") == "")
+
+	// only a single task may be interactive, as checked above
+	if tasks[0].Interactive {
+		if !ansi.IsTerminal(os.Stdin.Fd()) {
+			fmt.Fprintln(os.Stderr, errNotInteractive)
+			return exitFailure
+		}
+
+		state, err := ansi.MakeRaw(os.Stdin.Fd())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		defer ansi.Restore(os.Stdin.Fd(), state)
+	}
+
+	watchers, err := newWatchers(tasks, terminal)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCode(err)
+	}
+
+	// the first signal is forwarded to the command running, and once it
+	// arrives, the default behavior is restored, so that a second one
+	// forcefully ends the watcher while it tears down
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			signal.Stop(sigs)
+			cancel(&watcher.SignalError{Signal: sig})
+		case <-ctx.Done():
+		}
+	}()
+
+	var reloads <-chan struct{}
+	if fls.reloadConfig {
+		reloads = watchConfig(ctx, configName)
+	}
+
+	for {
+		// the watcher exits as soon as any of the tasks does, which is once
+		// they fail, or have run as many times as --once or --count say,
+		// stopping the others
+		runCtx, stop := context.WithCancel(ctx)
+		results := make(chan error, len(watchers))
+		for _, w := range watchers {
+			go func() { results <- w.Run(runCtx) }()
+		}
+
+		var next []*watcher.Watcher
+		for next == nil {
+			select {
+			case err := <-results:
+				stop()
+				for range len(watchers) - 1 {
+					<-results
+				}
+
+				return exitCode(err)
+
+			case <-reloads:
+				next = reload(cli, configName, terminal)
+			}
+		}
+
+		// the watchers of the previous configuration are done with before
+		// those of the new one start, so that their output does not mix
+		stop()
+		for range len(watchers) {
+			<-results
+		}
+
+		watchers = next
+	}
+}
+
+// loadTasks reads the configuration file and gives the tasks in it, or the
+// configuration itself as the only task, the flags taking precedence over
+// them, along with the flags merged with the configuration. Paths to watch
+// over or commands given as flags make up a task of their own, the tasks in
+// the file being left out, rather than being run by every one of them.
+func loadTasks(fls flagState) (flagState, []flagState, error) {
+	cfg, err := loadConfig(fls.config)
+	if err != nil {
+		return flagState{}, nil, err
+	}
+
+	tasks := []flagState{merge(cfg, fls)}
+	if len(cfg.tasks) != 0 && len(fls.Watch) == 0 && len(fls.Exec) == 0 {
+		tasks = make([]flagState, len(cfg.tasks))
+		for i, task := range cfg.tasks {
+			tasks[i] = merge(merge(cfg, task), fls)
+		}
+	}
+
+	return merge(cfg, fls), tasks, nil
+}
+
+// checkTasks tells whether the tasks can be run together. The standard input
+// is not read when reloading, as what reads it cannot be stopped.
+func checkTasks(tasks []flagState, reloading bool) error {
+	stateFiles := make(map[string]bool)
+
+	for _, task := range tasks {
+		if len(task.Exec) == 0 {
+			return taskError(task, errNoExecFlag)
+		}
+
+		if task.Interactive || task.TriggerStdin {
+			if reloading {
+				return errReloadStdin
+			}
+
+			if len(tasks) > 1 {
+				return errStdinTasks
+			}
+		}
+
+		if task.StateFile != "" {
+			if stateFiles[task.StateFile] {
+				return taskError(task, errSharedStateFile(task.StateFile))
+			}
+
+			stateFiles[task.StateFile] = true
+		}
+	}
+
+	return nil
+}
+
+// newWatchers creates a watcher for each of the tasks. The tasks share the
+// screen, so none of them clears it.
+func newWatchers(tasks []flagState, terminal bool) ([]*watcher.Watcher, error) {
+	watchers := make([]*watcher.Watcher, len(tasks))
+	for i, task := range tasks {
+		if !terminal || len(tasks) > 1 {
+			task.NoClear = true
+		}
+
+		w, err := watcher.New(task.Options)
+		if err != nil {
+			return nil, taskError(task, err)
+		}
+
+		watchers[i] = w
+	}
+
+	return watchers, nil
+}
+
+// reload reads the configuration file at name again and gives the watchers of
+// its tasks, which do not run initially, so that the new configuration applies
+// from the next change on. If it cannot be read, or its tasks cannot be run,
+// that is warned about and nil is returned, the watchers running being kept.
+func reload(cli flagState, name string, terminal bool) []*watcher.Watcher {
+	_, tasks, err := loadTasks(cli)
+	if err == nil {
+		err = checkTasks(tasks, true)
+	}
+
+	var watchers []*watcher.Watcher
+	if err == nil {
+		for i := range tasks {
+			tasks[i].NoInitial = true
+		}
+
+		watchers, err = newWatchers(tasks, terminal)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n", ansi.Yellow("failed to reload "+name+", keeping the configuration as it was: "+err.Error()))
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s\n\n", ansi.Gray("reloaded "+name))
+	return watchers
+}
+
+// argError tells which argument err is about, by its index in args, which is
+// shown counting from 1, as the shell does.
+func argError(i int, arg string, err error) error {
+	return fmt.Errorf("argument %d, %q: %w", i+1, arg, err)
+}
+
+// taskError tells which task err is of, if there are tasks.
+func taskError(task flagState, err error) error {
+	if task.Name == "" {
+		return err
+	}
+
+	return fmt.Errorf("task %s: %w", task.Name, err)
+}
+
+// list prints the paths that the tasks would watch over, one per line, or
+// ended by NUL bytes if print0 is set.
+func list(tasks []flagState, print0 bool) int {
+	var paths []string
+	for _, task := range tasks {
+		w, err := watcher.New(task.Options)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, taskError(task, err))
+			return exitCode(err)
+		}
+
+		listed, err := w.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, taskError(task, err))
+			return exitFailure
+		}
+
+		paths = append(paths, listed...)
+	}
+
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+
+	sep := "\n"
+	if print0 {
+		sep = "\x00"
+	}
+
+	for _, path := range paths {
+		fmt.Print(path, sep)
+	}
+
+	return exitSuccess
+}
+
+// printConfig prints the options of the tasks as the watchers have resolved
+// them, in the form of the configuration file, so that what has been taken
+// from the flags and from the file can be told, and kept.
+func printConfig(tasks []flagState) int {
+	cfgs := make([]config, len(tasks))
+	for i, task := range tasks {
+		w, err := watcher.New(task.Options)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, taskError(task, err))
+			return exitCode(err)
+		}
+
+		task.Options = w.Options()
+		cfgs[i] = configOf(task)
+	}
+
+	var out any = cfgs[0]
+	if len(cfgs) > 1 {
+		out = struct {
+			Tasks []config `json:"tasks"`
+		}{cfgs}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	return exitSuccess
+}
+
+// exitCode maps the error the watcher has stopped with to the exit code of the
+// application, as documented in the help text. The error of a command only
+// ever reaches here with --once, --count or --fail-fast.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.As(err, &exitErr):
+		// a command ended by a signal is told of as shells do, by 128 plus the
+		// signal, as there is no exit code of its own to pass on
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+
+		return exitFailure
+	case errors.Is(err, watcher.ErrUnsupportedOS):
+		return exitUnsupportedOS
+	default:
+		return exitFailure
+	}
+}
+
+func processFlags(args []string) (flagState, error) {
+	var fls flagState
+
+	currentFlag, flagName := flagWatch, ""
+
+	// pending is the index of the flag waiting for its value, if any
+	pending := -1
+
+	for i, arg := range args {
+		// the errors tell which argument they are about
+		fail := func(err error) (flagState, error) {
+			return flagState{}, argError(i, arg, err)
+		}
+
+		flag, ok := flags[arg]
+
+		// a -- right after an execution flag is part of the command, as it is,
+		// as is anything right after a bare --
+		if ok && pending != -1 && currentFlag == flagExec && (arg == "--" || flagName == "--") {
+			ok = false
+		}
+
+		if ok {
+			if pending != -1 {
+				return flagState{}, errMissingValue(args[pending], pending)
+			}
+
+			switch flag {
+			case flagRestart:
+				fls.Restart = true
+			case flagNotify:
+				fls.Notify = true
+			case flagNoClear:
+				fls.NoClear = true
+			case flagJSON:
+				fls.JSON = true
+			case flagJSONCapture:
+				fls.JSON, fls.JSONCapture = true, true
+			case flagRequireChange:
+				fls.RequireChange = true
+			case flagNoInitial:
+				fls.NoInitial = true
+			case flagNoShell:
+				fls.NoShell = true
+			case flagDryRun:
+				fls.DryRun = true
+			case flagVerbose:
+				fls.Verbose = true
+			case flagQuiet:
+				fls.Quiet = true
+			case flagOnce:
+				fls.Once = true
+			case flagFollowSymlinks:
+				fls.FollowSymlinks = true
+			case flagNoHeartbeat:
+				fls.NoHeartbeat = true
+			case flagAllowMissing:
+				fls.AllowMissing = true
+			case flagTriggerStdin:
+				fls.TriggerStdin = true
+			case flagNoColor:
+				fls.noColor = true
+			case flagInteractive:
+				fls.Interactive = true
+			case flagHash:
+				fls.Hash = true
+			case flagList:
+				fls.list = true
+			case flagIgnoreHidden:
+				fls.IgnoreHidden = true
+			case flagGit:
+				fls.Git = true
+			case flagSkipErrors:
+				fls.SkipErrors = true
+			case flagFastScan:
+				fls.FastScan = true
+			case flagNoPollFallback:
+				fls.NoPollFallback = true
+			case flagPreview:
+				fls.Preview = true
+			case flagPrint0:
+				fls.print0 = true
+			case flagIgnoreSelf:
+				fls.IgnoreSelf = true
+			case flagKeepOnFailure:
+				fls.KeepOnFailure = true
+			case flagCountInitial:
+				fls.CountInitial = true
+			case flagPrintConfig:
+				fls.printConfig = true
+			case flagFailFast:
+				fls.FailFast = true
+			case flagSummary:
+				fls.Summary = true
+			case flagMergeStderr:
+				fls.MergeStderr = true
+			case flagQuietStderr:
+				fls.QuietStderr = true
+			case flagCwdChanged:
+				fls.CwdChanged = true
+			case flagReloadConfig:
+				fls.reloadConfig = true
+			case flagPTY:
+				fls.PTY = true
+			case flagIgnoreFile:
+				fls.IgnoreFiles = append(fls.IgnoreFiles, ".gitignore")
+				currentFlag, flagName = flag, arg
+			default:
+				currentFlag, flagName = flag, arg
+				pending = i
+			}
+
+			continue
+		}
+
+		pending = -1
+
+		// values may start with a dash, such as those of -t or an unusual
+		// filename, but where a flag may also go, what looks like a flag is
+		// taken to be a mistyped one
+		if takesFlag(currentFlag) && isFlagLike(arg) {
+			return fail(errUnknownFlag)
+		}
+
+		switch currentFlag {
+		case flagWatch:
+			fls.Watch = append(fls.Watch, splitList(arg)...)
+
+		case flagIgnore:
+			fls.Ignore = append(fls.Ignore, splitList(arg)...)
+
+		case flagExec:
+			// everything after a bare -- is the command, as is
+			if flagName == "--" {
+				if err := validateCommand(args[i:]); err != nil {
+					return flagState{}, argError(i-1, flagName, err)
+				}
+
+				fls.Exec = [][]string{args[i:]}
+				return fls, nil
+			}
+
+			cmds, at, err := splitCommands(args[i:])
+			if errors.Is(err, errEmptyCommand) {
+				return flagState{}, errMissingValue(args[i+at], i+at)
+			}
+
+			if err != nil {
+				return flagState{}, argError(i+at, args[i+at], err)
+			}
+
+			fls.Exec = cmds
+			return fls, nil
+
+		case flagTickSpeed:
+			gran, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.TickSpeed != time.Duration(0) {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.TickSpeed = gran
+			currentFlag = flagAfterValue
+
+		case flagDebounce:
+			debounce, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.Debounce != time.Duration(0) {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Debounce = debounce
+			currentFlag = flagAfterValue
+
+		case flagEnv:
+			if err := validateEnv(arg); err != nil {
+				return fail(err)
+			}
+
+			fls.Env = append(fls.Env, arg)
+			currentFlag = flagAfterValue
+
+		case flagOnFail:
+			if fls.OnFail != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			if err := validateCommand([]string{arg}); err != nil {
+				return fail(err)
+			}
+
+			fls.OnFail = arg
+			currentFlag = flagAfterValue
+
+		case flagOnSuccess:
+			if fls.OnSuccess != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			if err := validateCommand([]string{arg}); err != nil {
+				return fail(err)
+			}
+
+			fls.OnSuccess = arg
+			currentFlag = flagAfterValue
+
+		case flagCwd:
+			if fls.Dir != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Dir = arg
+			currentFlag = flagAfterValue
+
+		case flagRoot:
+			if fls.Root != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Root = arg
+			currentFlag = flagAfterValue
+
+		case flagTail:
+			if fls.Tail != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Tail = arg
+			currentFlag = flagAfterValue
+
+		case flagBannerFormat:
+			if fls.BannerFormat != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.BannerFormat = arg
+			currentFlag = flagAfterValue
+
+		case flagStateFile:
+			if fls.StateFile != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.StateFile = arg
+			currentFlag = flagAfterValue
+
+		case flagBatchWindow:
+			window, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.BatchWindow != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.BatchWindow = window
+			currentFlag = flagAfterValue
+
+		case flagThrottle:
+			throttle, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.Throttle != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Throttle = throttle
+			currentFlag = flagAfterValue
+
+		case flagStartDelay:
+			delay, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.StartDelay != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.StartDelay = delay
+			currentFlag = flagAfterValue
+
+		case flagIgnoreLargerThan:
+			size, err := parseSize(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.IgnoreLargerThan != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.IgnoreLargerThan = size
+			currentFlag = flagAfterValue
+
+		case flagIgnoreOlderThan:
+			age, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.IgnoreOlderThan != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.IgnoreOlderThan = age
+			currentFlag = flagAfterValue
+
+		case flagTimeout:
+			timeout, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.Timeout != time.Duration(0) {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Timeout = timeout
+			currentFlag = flagAfterValue
+
+		case flagHeartbeatInterval:
+			interval, err := parseDuration(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.HeartbeatInterval != time.Duration(0) {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.HeartbeatInterval = interval
+			currentFlag = flagAfterValue
+
+		case flagExt:
+			if len(fls.Extensions) != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Extensions = strings.Split(arg, ",")
+			currentFlag = flagAfterValue
+
+		case flagRetry:
+			retries, err := parseCount(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.Retry != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Retry = retries
+			currentFlag = flagAfterValue
+
+		case flagCount:
+			count, err := parseCount(arg, flagName)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.Count != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Count = count
+			currentFlag = flagAfterValue
+
+		case flagLogLevel:
+			level, err := parseLogLevel(arg)
+			if err != nil {
+				return fail(err)
+			}
+
+			if fls.logLevelSet {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.LogLevel, fls.logLevelSet = level, true
+			currentFlag = flagAfterValue
+
+		case flagInclude:
+			fls.Include = append(fls.Include, arg)
+			currentFlag = flagAfterValue
+
+		case flagMaxDepth:
+			depth, err := strconv.Atoi(arg)
+			if err != nil {
+				return fail(errFailedToParseNumber)
+			}
+
+			if depth < 0 {
+				return fail(errNegative(flagName))
+			}
+
+			if fls.MaxDepth != 0 {
+				return fail(errAlreadySet(flagName))
+			}
+
+			// the flag counts the entries directly in the roots as at depth 0
+			fls.MaxDepth = depth + 1
+			currentFlag = flagAfterValue
+
+		case flagIgnoreFile:
+			fls.IgnoreFiles[len(fls.IgnoreFiles)-1] = arg
+			currentFlag = flagAfterValue
+
+		case flagShell:
+			if fls.Shell != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.Shell = arg
+			currentFlag = flagAfterValue
+
+		case flagConfig:
+			if fls.config != "" {
+				return fail(errAlreadySet(flagName))
+			}
+
+			fls.config = arg
+			currentFlag = flagAfterValue
+
+		case flagAfterValue:
+			return fail(errArgAfterValueFlag(flagName))
+
+		}
+	}
+
+	// a flag may not be left without its value at the end, while the commands
+	// may still come from the configuration file
+	if pending != -1 {
+		return flagState{}, errMissingValue(args[pending], pending)
+	}
+
+	return fls, nil
+}
+
+// splitList splits a comma-separated list of paths or patterns, leaving out
+// the empty ones, as of doubled or trailing commas. Commas escaped with a
+// backslash are kept as part of the paths.
+func splitList(arg string) []string {
+	var list []string
+	var item strings.Builder
+
+	for i := 0; i < len(arg); i++ {
+		switch {
+		case arg[i] == '\\' && i+1 < len(arg) && arg[i+1] == ',':
+			item.WriteByte(',')
+			i++
+
+		case arg[i] == ',':
+			if item.Len() != 0 {
+				list = append(list, item.String())
+			}
+			item.Reset()
+
+		default:
+			item.WriteByte(arg[i])
+		}
+	}
+
+	if item.Len() != 0 {
+		list = append(list, item.String())
+	}
+
+	return list
+}
+
+// splitCommands splits the arguments after the first execution flag into one
+// command per execution flag. Other flags, and --, are taken as part of the
+// commands. If a command is not valid, the index of its execution flag is
+// returned along with the error, -1 being the one before the arguments.
+func splitCommands(args []string) ([][]string, int, error) {
+	cmds, flagAt := [][]string{nil}, []int{-1}
+
+	for i, arg := range args {
+		if flag, ok := flags[arg]; ok && flag == flagExec && arg != "--" {
+			cmds, flagAt = append(cmds, nil), append(flagAt, i)
+			continue
+		}
+
+		cmds[len(cmds)-1] = append(cmds[len(cmds)-1], arg)
+	}
+
+	for i, cmd := range cmds {
+		if err := validateCommand(cmd); err != nil {
+			return nil, flagAt[i], err
+		}
+	}
+
+	return cmds, 0, nil
+}
+
+// validateCommand checks that cmd has at least one argument that is not blank,
+// as in -e "", which a shell would otherwise run as doing nothing.
+func validateCommand(cmd []string) error {
+	if len(cmd) == 0 {
+		return errEmptyCommand
+	}
+
+	for _, arg := range cmd {
+		if strings.TrimSpace(arg) != "" {
+			return nil
+		}
+	}
+
+	return errBlankCommand
+}
+
+func parseCount(arg, flag string) (int, error) {
+	num, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, errFailedToParseNumber
+	}
+
+	if num <= 0 {
+		return 0, errNonPositive(flag)
+	}
+
+	return num, nil
+}
+
+// parseSize parses a number of bytes, which may be followed by K, M or G for
+// kibibytes, mebibytes or gibibytes.
+func parseSize(arg, flag string) (int64, error) {
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(arg, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(arg, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(arg, "G"):
+		unit = 1 << 30
+	}
+
+	if unit != 1 {
+		arg = arg[:len(arg)-1]
+	}
+
+	num, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errFailedToParseSize
+	}
+
+	if num <= 0 {
+		return 0, errNonPositive(flag)
+	}
+
+	return num * unit, nil
+}
+
+func validateEnv(env string) error {
+	key, _, ok := strings.Cut(env, "=")
+	if !ok || key == "" {
+		return errMalformedEnv(env)
+	}
+
+	return nil
+}
+
+func parseLogLevel(arg string) (watcher.LogLevel, error) {
+	for _, level := range []watcher.LogLevel{watcher.LevelDebug, watcher.LevelInfo, watcher.LevelWarn, watcher.LevelError} {
+		if arg == level.String() {
+			return level, nil
+		}
+	}
+
+	return 0, errUnknownLogLevel(arg)
+}
+
+// parseDuration parses a duration such as 1s, 250ms or 2m, or a bare number,
+// which is taken as milliseconds.
+func parseDuration(arg, flag string) (time.Duration, error) {
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		num, err := strconv.ParseInt(arg, 10, 0)
+		if err != nil {
+			return 0, errFailedToParseDuration
+		}
+
+		d = time.Duration(num) * time.Millisecond
+	}
+
+	if d <= 0 {
+		return 0, errNonPositive(flag)
+	}
+
+	return d, nil
+}
+
+// takesFlag reports whether a flag may take the place of the argument after
+// the given one, rather than it being a value.
+func takesFlag(flag int) bool {
+	return flag == flagWatch || flag == flagIgnore || flag == flagAfterValue
+}
+
+// isFlagLike reports whether arg looks like a flag, that is, a long one or a
+// short one of a single letter, so that -weird.txt or -5 are not.
+func isFlagLike(arg string) bool {
+	if strings.HasPrefix(arg, "--") {
+		return true
+	}
+
+	return len(arg) == 2 && arg[0] == '-' && unicode.IsLetter(rune(arg[1]))
+}
+
+func help() {
+	version(true)
+	fmt.Println(helpString)
+}
+
+// version prints the version of the watcher, along with the Go version and
+// the revision it has been built from, as far as the build information tells,
+// unless short is set.
+func version(short bool) {
+	var info *debug.BuildInfo
+	if !short {
+		info, _ = debug.ReadBuildInfo()
+	}
+
+	writeVersion(os.Stdout, info)
+}
+
+// writeVersion writes the version of the watcher to w, along with what info
+// tells of the build, if given.
+func writeVersion(w io.Writer, info *debug.BuildInfo) {
+	fmt.Fprintf(w, "watcher %s for %s\n", Version, runtime.GOOS)
+	if info == nil {
+		return
+	}
+
+	settings := make(map[string]string)
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+
+	fmt.Fprintf(w, "    go:        %s %s/%s\n", info.GoVersion, settings["GOOS"], settings["GOARCH"])
+
+	if revision, ok := settings["vcs.revision"]; ok {
+		if settings["vcs.modified"] == "true" {
+			revision += " (modified)"
+		}
+
+		fmt.Fprintf(w, "    revision:  %s\n", revision)
+	}
+
+	if at, ok := settings["vcs.time"]; ok {
+		fmt.Fprintf(w, "    committed: %s\n", at)
+	}
+}
+
+const helpString = `
+synopsis:
+    watcher --help
+    watcher --version [ --short ]
+    watcher { <filepath> } { <option> } ( --exec | -e ) <command> [ <args> ] { ( --exec | -e ) <command> [ <args> ] }
+    watcher { <filepath> } { <option> } -- <command> [ <args> ]
+    watcher { <filepath> } { <option> } --list
+
+description:
+    watches for changes on the given files and directories (and files inside the given directories)
+    over a period of time and runs the given command whenever any changes are detected. when more
+    than one command is given, they are run in order, stopping at the first one that fails.
+    the output of the watcher itself goes to the standard error, leaving the standard output to the
+    commands.
+
+directives:
+    <filepath>     - path to a file or directory.
+    <command>      - any command.
+    <args>         - arguments to be passed to the command.
+    <milliseconds> - number of milliseconds, or a duration such as 1s, 250ms or 2m.
+
+    any {} in the command is replaced by the path of the file that has changed, which is empty on the
+    first execution.
+
+    the command is given the WATCHER_CHANGED_FILE environment variable, holding the same path,
+    WATCHER_CHANGED_FILES, holding every path that has changed, one per line, as with --batch-window,
+    and WATCHER_CHANGE_COUNT, holding the number of changes so far, which is 0 on the first execution.
+
+    ignore patterns are relative to each directory watched over, where ** matches any number of
+    directories, so that build ignores only the build directory at the top, and **/build ignores
+    every one of them.
+
+    include patterns beginning with ! exclude the files they match, the last pattern matching a file
+    deciding, as in --include "*.go" --include "!*_test.go".
+
+    the paths to watch over and the ignore patterns may also be comma-separated, as in -w src,cmd,
+    with the commas that are part of a path escaped with a backslash, as in a\,b.
+
+    the paths to watch over may be patterns as well, such as "src/**/*.go", which are expanded once,
+    when the watcher starts. patterns that match nothing are taken as they are.
+
+    with --trigger-stdin, the standard input is read by the watcher, so the command is given none, as
+    it could otherwise take the lines meant as triggers.
+
+    the --banner-format tokens are {time}, {file}, {count}, {event}, {change}, {task} and {retry}.
+
+    with --tail, the command is given the data appended to the file, or written to the pipe, on its
+    standard input. files are read from their end as of when the watcher starts, and from their start
+    once truncated or replaced. pipes are held open, so that the watcher waits while nothing writes.
+
+    --fast-scan relies on the mod time of a directory changing whenever entries are added to, removed
+    from or renamed within it, which holds on Linux, the BSDs, macOS and NTFS, but not on FAT nor on
+    some network filesystems. it has no effect along with --follow-symlinks.
+    
+    options:
+        --help | -h                          - displays this screen.
+    	--version | -v                       - displays the version of the application and how it was built.
+    	--config <filepath>                  - reads the options from a file, watcher.json by default.
+    	--reload-config                      - restarts the watcher with the configuration file once it has changed.
+    	( --watch | -w ) { <filename> }      - adds more filepaths to watch.
+    	( --ignore | -i ) { <filename> }     - skips the paths matching the patterns given after this flag.
+    	( --tick-speed | -t ) <milliseconds> - defines the wait time in between watches, at least 10.
+    	--poll-interval <milliseconds>       - same as --tick-speed.
+    	( --restart | -r )                   - restarts the command on changes instead of waiting for it.
+    	--debounce <milliseconds>            - waits for changes to settle for this long before running.
+    	--batch-window <milliseconds>        - runs once for all changes made this long after the first one.
+    	--throttle <milliseconds>            - runs at once on a change, then at most once this often, unlike --debounce.
+    	--start-delay <milliseconds>         - waits this long before the first execution.
+    	--preview                            - lists what has changed and counts down to the run it is held back for.
+    	--timeout <milliseconds>             - terminates the command if it runs for longer than this.
+    	--retry <count>                      - runs the command again when it fails, up to this many times.
+    	--fail-fast                          - exits as soon as the command fails, once out of retries.
+    	--on-fail <command>                  - runs this after the command fails, as in notify-send failed.
+    	--on-success <command>               - runs this after the command succeeds.
+    	--ignore-file [ <filepath> ]         - reads ignore patterns from a file, .gitignore by default.
+    	--ignore-hidden                      - skips the files and directories whose names begin with a dot.
+    	--ignore-larger-than <size>          - skips the files larger than this, in bytes or as in 10M.
+    	--ignore-older-than <milliseconds>   - skips the files last modified this long before starting.
+    	--git                                - skips .git and what the .gitignore files in the tree ignore.
+    	--ignore-self                        - ignores what changes while the command runs, such as what it writes.
+    	--max-depth <depth>                  - walks at most this deep below each path, 0 being only the entries in it.
+    	--ext <extensions>                   - detects changes only in files with one of these, as in go,templ.
+    	--include <pattern>                  - detects changes only in files matching any of these, as in "*.go".
+    	--hash                               - detects changes by the contents of the files, not their mod times.
+    	--fast-scan                          - lists again only the directories whose mod time has changed.
+    	( --notify | -n )                    - waits for filesystem notifications instead of polling.
+    	--no-poll-fallback                   - fails instead of polling when notifications cannot be set up.
+    	--trigger-stdin                      - runs the command for every line read, as if it were a changed path.
+    	--interactive                        - pauses and resumes on space, and runs the command on r.
+    	--tail <filepath>                    - runs the command whenever data is appended to this file or pipe.
+    	--follow-symlinks                    - walks into the directories symbolic links lead to.
+    	--allow-missing                      - watches over paths that do not exist yet.
+    	--skip-errors                        - skips the paths that cannot be read instead of stopping.
+    	--no-clear                           - keeps the output of previous executions on the screen.
+    	--banner-format <format>             - prints this line before every run, as in "{time} {event} {file}".
+    	--keep-on-failure                    - does not clear the screen after the command fails.
+    	--no-color                           - prints without colors, as when NO_COLOR is set or not on a terminal.
+    	--no-heartbeat                       - prints nothing in between executions.
+    	--heartbeat-interval <milliseconds>  - refreshes the time printed in between executions at most this often.
+    	--json                               - writes a JSON object per execution instead of banners.
+    	--json-capture                       - like --json, but the output of the command goes in the objects.
+    	--require-change                     - skips the first execution if the command uses {}.
+    	( --no-initial | --on-start=false )  - skips the first execution, running only on changes.
+    	--once                               - exits after the first change, with the status of the command.
+    	--count <count>                      - exits after running for this many changes, with the last status.
+    	--count-initial                      - counts the first execution towards --count.
+    	--cwd <directory>                    - runs the command in this directory instead of the current one.
+    	--cwd-changed                        - runs the command in the directory of the changed file, or in --cwd at first.
+    	--root <directory>                   - takes relative paths from this directory and runs the command in it.
+    	--state-file <filepath>              - keeps the files as they were on the last run in this file, across restarts.
+    	--env <key>=<value>                  - gives the command this environment variable as well.
+    	--shell <filepath>                   - runs the command through the given shell.
+    	--no-shell                           - runs the command directly, not through a shell.
+    	--merge-stderr                       - sends the standard error of the command to its standard output.
+    	--quiet-stderr                       - leaves out the standard error of the command.
+    	--pty                                - runs the command attached to a pseudo-terminal, as if its output went to a terminal.
+    	--list                               - prints the paths that would be watched over and exits.
+    	--print0                             - ends the paths --list prints with NUL instead of newline, as xargs -0 reads.
+    	--print-config                       - prints the options as understood, defaults included, as JSON and exits.
+    	--dry-run                            - prints the commands instead of running them, and what is ignored.
+    	--summary                            - prints how many runs succeeded and failed, and for how long, on exit.
+    	--verbose                            - reports each scan, skipped path and change, as --log-level debug.
+    	( --quiet | -q )                     - prints only the output of the command, warnings and errors.
+    	--log-level <level>                  - prints only what is of this level or above: debug, info, warn, error.
+    	( --exec | -e ) <command> [ <args> ] - command to be executed when changes are detected.
+    	-- <command> [ <args> ]              - same as --exec, but everything after it is taken as the command.
+
+configuration:
+    the options can also be read from a JSON file, given with --config, or from watcher.json in the
+    current directory if it exists. its fields mirror the flags, such as "watch", "ignore", "exec",
+    "tick_speed" or "no_initial", durations being in milliseconds. the flags given take precedence.
+
+exit status:
+    0 - the watcher has been interrupted, or the command has succeeded with --once or --count.
+    1 - the options are invalid, or the watcher has failed.
+    2 - the operating system is not supported.
+
+    with --once or --count, the exit status of the last run of the command is used instead, as is
+    that of the command failing with --fail-fast, or 1 if it has timed out. a command ended by a
+    signal gives 128 plus the signal, as in 130 for SIGINT.
+
+example:
+    watcher . --ignore .git node_modules .gitignore -t 1000 --exec build.sh
+
+    	watches over changes every second (-t 1000) on the current directory (.), except for the
+		.git and node_modules directories and the .gitignore file. If any changes are detected,
+		build.sh is run and its output will be displayed on the standard output.
+		
+	watcher src --tick-speed 3000 -e "go test -v ./..."
+
+		watches for changes every three second (--tick-speed 3000) in the src directory and runs go
+		test -v ./... whenever a change is detected.`
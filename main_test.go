@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alan-b-lima/watcher/watcher"
+)
+
+// mainArgs is the environment variable the test binary is given the arguments
+// of the watcher in, separated by newlines, to run as the watcher itself.
+const mainArgs = "WATCHER_TEST_ARGS"
+
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv(mainArgs); ok {
+		os.Args = append([]string{"watcher"}, strings.Split(args, "\n")...)
+		if args == "" {
+			os.Args = os.Args[:1]
+		}
+
+		main()
+	}
+
+	os.Exit(m.Run())
+}
+
+// watcherCommand returns the command running the watcher with args, in dir.
+func watcherCommand(t *testing.T, dir string, args ...string) *exec.Cmd {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), mainArgs+"="+strings.Join(args, "\n"), "NO_COLOR=1")
+	return cmd
+}
+
+// runWatcher runs the watcher with args, in dir, and returns what it has
+// written to its standard output and error, and its exit code.
+func runWatcher(t *testing.T, dir string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+
+	var outBuf, errBuf bytes.Buffer
+	cmd := watcherCommand(t, dir, args...)
+	cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatal(err)
+	}
+
+	return outBuf.String(), errBuf.String(), cmd.ProcessState.ExitCode()
+}
+
+// tempTree creates the empty files under a new temporary directory, along with
+// the directories they are in, and returns its path, with the symbolic links
+// in it resolved, as the watcher reports it.
+func tempTree(t *testing.T, names ...string) string {
+	t.Helper()
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestTickSpeedMilliseconds(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want time.Duration
+	}{
+		{"100", 100 * time.Millisecond},
+		{"1000", time.Second},
+		{"10", 10 * time.Millisecond},
+		{"15", 15 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		fls, err := processFlags([]string{".", "-t", tt.arg, "-e", "true"})
+		if err != nil {
+			t.Fatalf("-t %s: %v", tt.arg, err)
+		}
+
+		if fls.TickSpeed != tt.want {
+			t.Errorf("-t %s: got %s, want %s", tt.arg, fls.TickSpeed, tt.want)
+		}
+	}
+}
+
+func TestTickSpeedDurations(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want time.Duration
+	}{
+		{"1s", time.Second},
+		{"250ms", 250 * time.Millisecond},
+		{"1.5s", 1500 * time.Millisecond},
+		{"2m", 2 * time.Minute},
+		{"1000", time.Second},
+	}
+
+	for _, tt := range tests {
+		fls, err := processFlags([]string{".", "--tick-speed", tt.arg, "-e", "true"})
+		if err != nil {
+			t.Fatalf("--tick-speed %s: %v", tt.arg, err)
+		}
+
+		if fls.TickSpeed != tt.want {
+			t.Errorf("--tick-speed %s: got %s, want %s", tt.arg, fls.TickSpeed, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{
+		{".", "-t", "0", "-e", "true"},
+		{".", "-t", "-1s", "-e", "true"},
+		{".", "-t", "fast", "-e", "true"},
+		{".", "-t", "1s", "-t", "2s", "-e", "true"},
+	} {
+		if _, err := processFlags(args); err == nil {
+			t.Errorf("%q: got no error", args)
+		}
+	}
+}
+
+func TestBannerNotOnStdout(t *testing.T) {
+	stdout, stderr, code := runWatcher(t, t.TempDir(), ".", "--once", "--count-initial", "-e", "echo out")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr: %q", code, stderr)
+	}
+
+	if stdout != "out\n" {
+		t.Errorf("got %q on stdout, want only the command's output", stdout)
+	}
+
+	if !strings.Contains(stderr, "First execution") {
+		t.Errorf("got %q on stderr, want the banner", stderr)
+	}
+}
+
+func TestDashPrefixedValues(t *testing.T) {
+	fls, err := processFlags([]string{"-weird.txt", "-w", "-other.txt", "-i", "-weird.log", "-e", "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"-weird.txt", "-other.txt"}; !slices.Equal(fls.Watch, want) {
+		t.Errorf("got %q watched over, want %q", fls.Watch, want)
+	}
+
+	if want := []string{"-weird.log"}; !slices.Equal(fls.Ignore, want) {
+		t.Errorf("got %q ignored, want %q", fls.Ignore, want)
+	}
+
+	if _, err := processFlags([]string{".", "-x", "-e", "true"}); !errors.Is(err, errUnknownFlag) {
+		t.Errorf("-x: got %v, want %v", err, errUnknownFlag)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := tempTree(t, "a.go", "notes.md", "src/b.go", "build/out.go")
+
+	stdout, stderr, code := runWatcher(t, dir, ".", "-i", "build", "--ext", "go", "--list")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr: %q", code, stderr)
+	}
+
+	want := filepath.Join(dir, "a.go") + "\n" + filepath.Join(dir, "src", "b.go") + "\n"
+	if stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestCwd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pwd is run through sh")
+	}
+
+	dir := tempTree(t, "sub/a.txt")
+
+	stdout, stderr, code := runWatcher(t, dir, ".", "--cwd", "sub", "--once", "--count-initial", "-e", "pwd")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr: %q", code, stderr)
+	}
+
+	if want := filepath.Join(dir, "sub") + "\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	for _, cwd := range []string{"missing", "sub/a.txt"} {
+		if _, _, code := runWatcher(t, dir, ".", "--cwd", cwd, "--once", "-e", "pwd"); code != 1 {
+			t.Errorf("--cwd %s: got exit code %d, want 1", cwd, code)
+		}
+	}
+}
+
+func TestEnvOverridesInherited(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the variable is expanded by sh")
+	}
+
+	t.Setenv("WATCHER_TEST_VAR", "inherited")
+
+	stdout, stderr, code := runWatcher(t, tempTree(t), ".", "--env", "WATCHER_TEST_VAR=given", "--env", "OTHER=a=b", "--once", "--count-initial", "-e", "echo $WATCHER_TEST_VAR $OTHER")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr: %q", code, stderr)
+	}
+
+	if want := "given a=b\n"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+
+	for _, env := range []string{"NOVALUE", "=value"} {
+		if _, err := processFlags([]string{".", "--env", env, "-e", "true"}); err == nil {
+			t.Errorf("--env %s: got no error", env)
+		}
+	}
+}
+
+func TestVersionBuildInfo(t *testing.T) {
+	info := &debug.BuildInfo{
+		GoVersion: "go1.24.5",
+		Settings: []debug.BuildSetting{
+			{Key: "GOOS", Value: "linux"},
+			{Key: "GOARCH", Value: "amd64"},
+			{Key: "vcs.revision", Value: "3c27117"},
+			{Key: "vcs.modified", Value: "true"},
+			{Key: "vcs.time", Value: "2025-07-01T12:00:00Z"},
+		},
+	}
+
+	var b strings.Builder
+	writeVersion(&b, info)
+
+	for _, want := range []string{"go1.24.5 linux/amd64", "3c27117 (modified)", "2025-07-01T12:00:00Z"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("%q is missing from %q", want, b.String())
+		}
+	}
+
+	b.Reset()
+	writeVersion(&b, nil)
+
+	if lines := strings.Count(b.String(), "\n"); lines != 1 {
+		t.Errorf("got %d lines without build information, want 1: %q", lines, b.String())
+	}
+}
+
+func TestListPrint0(t *testing.T) {
+	dir := tempTree(t, "a b.txt")
+
+	stdout, stderr, code := runWatcher(t, dir, ".", "--list", "--print0")
+	if code != 0 {
+		t.Fatalf("exit code %d, stderr: %q", code, stderr)
+	}
+
+	if want := dir + "\x00" + filepath.Join(dir, "a b.txt") + "\x00"; stdout != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+}
+
+func TestBlankCommands(t *testing.T) {
+	tests := [][]string{
+		{".", "-e", ""},
+		{".", "-e", "   "},
+		{".", "-e", "\t\n"},
+		{".", "-e", "make", "-e", " "},
+		{".", "--", ""},
+		{".", "--on-fail", " ", "-e", "make"},
+		{".", "--on-success", "", "-e", "make"},
+	}
+
+	for _, args := range tests {
+		if _, err := processFlags(args); !errors.Is(err, errBlankCommand) {
+			t.Errorf("%q: got %v, want %v", args, err, errBlankCommand)
+		}
+	}
+
+	if _, err := processFlags([]string{".", "-e", " make "}); err != nil {
+		t.Errorf("a command surrounded by spaces: %v", err)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		{"src", []string{"src"}},
+		{"src,cmd,internal", []string{"src", "cmd", "internal"}},
+		{"src,,cmd,", []string{"src", "cmd"}},
+		{",", nil},
+		{`a\,b,c`, []string{"a,b", "c"}},
+		{`a\b,c`, []string{`a\b`, "c"}},
+		{`trailing\`, []string{`trailing\`}},
+	}
+
+	for _, tt := range tests {
+		if got := splitList(tt.arg); !slices.Equal(got, tt.want) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+
+	fls, err := processFlags([]string{"-w", "src,cmd", "-w", "docs", "-i", `a\,b,,c`, "-e", "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"src", "cmd", "docs"}; !slices.Equal(fls.Watch, want) {
+		t.Errorf("got %q watched over, want %q", fls.Watch, want)
+	}
+
+	if want := []string{"a,b", "c"}; !slices.Equal(fls.Ignore, want) {
+		t.Errorf("got %q ignored, want %q", fls.Ignore, want)
+	}
+}
+
+func TestArgumentPositions(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{".", "-i"}, "missing value for -i (argument 2)"},
+		{[]string{".", "-i", "-e", "true"}, "missing value for -i (argument 2)"},
+		{[]string{".", "-t", "100", "-e"}, "missing value for -e (argument 4)"},
+		{[]string{".", "-t", "100", "foo", "-e", "true"}, `argument 4, "foo": only one argument should be passed after -t`},
+		{[]string{".", "-t", "100", "-t", "200", "-e", "true"}, `argument 5, "200": -t has already been set`},
+		{[]string{".", "--bogus", "-e", "true"}, `argument 2, "--bogus": unknown flag`},
+	}
+
+	for _, tt := range tests {
+		_, err := processFlags(tt.args)
+		if err == nil {
+			t.Errorf("%q: got no error, want %q", tt.args, tt.want)
+			continue
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestDashDashAfterExec(t *testing.T) {
+	tests := []struct {
+		args []string
+		want [][]string
+	}{
+		{[]string{".", "-e", "--", "echo", "hi"}, [][]string{{"--", "echo", "hi"}}},
+		{[]string{".", "--exec", "--"}, [][]string{{"--"}}},
+		{[]string{".", "-e", "make", "--", "-j4"}, [][]string{{"make", "--", "-j4"}}},
+		{[]string{".", "--", "-e", "x"}, [][]string{{"-e", "x"}}},
+	}
+
+	for _, tt := range tests {
+		fls, err := processFlags(tt.args)
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+
+		if !slices.EqualFunc(fls.Exec, tt.want, slices.Equal) {
+			t.Errorf("%q: got %q, want %q", tt.args, fls.Exec, tt.want)
+		}
+	}
+
+	if _, err := processFlags([]string{".", "-i", "--", "echo"}); err == nil || err.Error() != "missing value for -i (argument 2)" {
+		t.Errorf("-i --: got %v, want a missing value", err)
+	}
+}
+
+func TestLogLevelSetOnce(t *testing.T) {
+	for _, levels := range [][]string{{"info", "debug"}, {"debug", "info"}, {"info", "info"}} {
+		args := []string{".", "--log-level", levels[0], "--log-level", levels[1], "-e", "true"}
+		if _, err := processFlags(args); err == nil {
+			t.Errorf("%q: got no error", args)
+		}
+	}
+
+	cfg := flagState{logLevelSet: true}
+	cfg.LogLevel = watcher.LevelDebug
+
+	fls, err := processFlags([]string{".", "--log-level", "info", "-e", "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := merge(cfg, fls).LogLevel; got != watcher.LevelInfo {
+		t.Errorf("got %s after merging, want the level given explicitly, %s", got, watcher.LevelInfo)
+	}
+
+	if got := merge(cfg, flagState{}).LogLevel; got != watcher.LevelDebug {
+		t.Errorf("got %s after merging, want the level of the configuration, %s", got, watcher.LevelDebug)
+	}
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// syncWriter is a bytes.Buffer that may be read while the watcher writes to it.
+type syncWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *syncWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestSignalsWhileTearingDown(t *testing.T) {
+	var stderr syncWriter
+	cmd := watcherCommand(t, t.TempDir(), ".", "--no-heartbeat", "-e", "sleep 5")
+	cmd.Stderr = &stderr
+
+	// the command may outlive the watcher, holding onto its standard error
+	cmd.WaitDelay = 100 * time.Millisecond
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(stderr.String(), "First execution") {
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			t.Fatalf("the command has not started: %s", stderr.String())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the second signal arrives while the first one is being handled
+	cmd.Process.Signal(syscall.SIGTERM)
+	cmd.Process.Signal(syscall.SIGTERM)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("the watcher has not exited")
+	}
+
+	if strings.Contains(stderr.String(), "panic") {
+		t.Errorf("the watcher has panicked: %s", stderr.String())
+	}
+}
@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"encoding/json"
+	"io"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event is a change the commands are run for, as given to the OnChange hook.
+// Kind is one of start, for the first execution, add, remove, rename, change,
+// trigger and append, as in the JSON events. Files holds every path that has
+// changed, of which Path is the most relevant. Appended holds the data
+// appended to the Tail file, if any.
+type Event struct {
+	Kind     string
+	Path     string
+	OldPath  string
+	Files    []string
+	Appended []byte
+	Time     time.Time
+}
+
+// Event returns the change r is for as an Event.
+func (r *run) Event() Event {
+	return Event{
+		Kind:     r.change.event(),
+		Path:     r.change.path,
+		OldPath:  r.change.oldPath,
+		Files:    slices.Clone(r.change.files),
+		Appended: slices.Clone(r.change.appended),
+		Time:     r.time,
+	}
+}
+
+// event is a single line of the --json output. Its fields are part of the
+// output format, so they should only ever be added to.
+type event struct {
+	Event       string    `json:"event"`
+	Task        string    `json:"task,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	OldPath     string    `json:"old_path,omitempty"`
+	Files       []string  `json:"files,omitempty"`
+	Time        time.Time `json:"time"`
+	CommandExit *int      `json:"command_exit,omitempty"`
+	DurationMs  *int64    `json:"duration_ms,omitempty"`
+	Stdout      *string   `json:"stdout,omitempty"`
+	Stderr      *string   `json:"stderr,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DryRun      []string  `json:"dry_run,omitempty"`
+	Retry       int       `json:"retry,omitempty"`
+}
+
+// eventEncoder serializes the writing of events, since commands left running
+// in restart mode report their exit from another goroutine. Every event is
+// given the name of the task, if any.
+type eventEncoder struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	task string
+}
+
+func newEventEncoder(w io.Writer, task string) *eventEncoder {
+	return &eventEncoder{enc: json.NewEncoder(w), task: task}
+}
+
+func (e *eventEncoder) encode(ev event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ev.Task = e.task
+	e.enc.Encode(ev)
+}
+
+func (ch change) event() string {
+	switch ch.kind {
+	case changeAdded:
+		return "add"
+	case changeRemoved:
+		return "remove"
+	case changeRenamed:
+		return "rename"
+	case changeModified:
+		return "change"
+	case changeTriggered:
+		return "trigger"
+	case changeAppended:
+		return "append"
+	default:
+		return "start"
+	}
+}
+
+func (w *Watcher) handleJSON(r *run, err error) error {
+	ev := event{
+		Event:   r.change.event(),
+		Path:    r.change.path,
+		OldPath: r.change.oldPath,
+		Files:   r.change.files,
+		Time:    r.time,
+		Retry:   r.attempt,
+	}
+
+	if !w.opts.DryRun {
+		ms := r.duration.Milliseconds()
+		ev.DurationMs = &ms
+	}
+
+	if w.opts.JSONCapture {
+		stdout, stderr := r.stdout.String(), r.stderr.String()
+		ev.Stdout, ev.Stderr = &stdout, &stderr
+	}
+
+	switch err := err.(type) {
+	case nil:
+		if w.opts.DryRun {
+			ev.DryRun = r.commands
+			break
+		}
+
+		code := 0
+		ev.CommandExit = &code
+
+	case *exec.ExitError:
+		code := err.ExitCode()
+		ev.CommandExit = &code
+
+	case *unsupportedOSError, *startProcessFailureError:
+		ev.Error = err.Error()
+		w.encoder.encode(ev)
+		return err
+
+	default:
+		ev.Error = err.Error()
+	}
+
+	w.encoder.encode(ev)
+	return nil
+}
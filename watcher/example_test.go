@@ -0,0 +1,50 @@
+package watcher_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/alan-b-lima/watcher/watcher"
+)
+
+func ExampleOptions_run() {
+	dir, err := os.MkdirTemp("", "example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runs := 0
+	w, err := watcher.New(watcher.Options{
+		Watch:       []string{dir},
+		TickSpeed:   watcher.MinTickSpeed,
+		Count:       1,
+		NoHeartbeat: true,
+		Stderr:      io.Discard,
+		Run: func(ctx context.Context, ev watcher.Event) error {
+			runs++
+			fmt.Printf("run %d: %s\n", runs, ev.Kind)
+
+			if ev.Kind == "start" {
+				return os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644)
+			}
+
+			return nil
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := w.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Output:
+	// run 1: start
+	// run 2: add
+}
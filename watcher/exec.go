@@ -0,0 +1,994 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alan-b-lima/watcher/ansi-escape"
+)
+
+// run is a single execution of the commands and what triggered it.
+type run struct {
+	change change
+	time   time.Time
+
+	// count is the number of changes the commands have been run for so far,
+	// including this one.
+	count int
+
+	// attempt is the number of times the commands have been retried for the
+	// change, 0 on the first run.
+	attempt int
+
+	// duration is how long the commands have taken to run, all together.
+	duration time.Duration
+
+	// commands are the command lines resolved in dry-run mode.
+	commands []string
+
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// process tracks the command currently running out of a chain of commands,
+// so that the chain can be stopped in between steps.
+type process struct {
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	cancel   context.CancelFunc
+	stopping bool
+
+	done chan struct{}
+}
+
+type unsupportedOSError struct {
+	error
+}
+
+func (err *unsupportedOSError) Unwrap() error {
+	return err.error
+}
+
+type startProcessFailureError struct {
+	error
+}
+
+type timeoutError struct {
+	error
+}
+
+// funcError is the error the Run option has failed with.
+type funcError struct {
+	error
+}
+
+func (err *funcError) Unwrap() error {
+	return err.error
+}
+
+// SignalError is a cause the context given to Run may be canceled with, in
+// context.WithCancelCause, so that the signal is forwarded to the commands
+// running, rather than them being sent the usual SIGTERM. On Windows, the
+// commands are terminated as usual.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (err *SignalError) Error() string {
+	return "received " + err.Signal.String()
+}
+
+// signalOf returns the signal ctx has been canceled because of, if any.
+func signalOf(ctx context.Context) os.Signal {
+	var serr *SignalError
+	if errors.As(context.Cause(ctx), &serr) {
+		return serr.Signal
+	}
+
+	return nil
+}
+
+// executeAndHandle runs the commands for the given change. Once ctx is done,
+// the command running is terminated.
+func (w *Watcher) executeAndHandle(ctx context.Context, ch change) error {
+	r := w.newRun(ch)
+	if w.onChange(r) {
+		return nil
+	}
+
+	return w.executeRun(ctx, r)
+}
+
+// executeRun runs the commands for r, reporting it to be retried if they fail.
+func (w *Watcher) executeRun(ctx context.Context, r *run) error {
+	w.stop()
+
+	w.latest = r
+	w.banner(r)
+
+	proc := &process{done: make(chan struct{})}
+	if !w.opts.Restart {
+		defer close(proc.done)
+		defer context.AfterFunc(ctx, func() { proc.interrupt(signalOf(ctx)) })()
+
+		err := w.execute(r, proc)
+		if isFailure(err) {
+			w.reportFailure(r)
+		}
+
+		if err := w.handle(r, err); err != nil {
+			return err
+		}
+
+		w.hook(ctx, r, err)
+		if w.failsFast(r, err) {
+			return err
+		}
+
+		return nil
+	}
+
+	go func() {
+		defer close(proc.done)
+		defer context.AfterFunc(ctx, func() { proc.interrupt(signalOf(ctx)) })()
+
+		err := w.execute(r, proc)
+		if !w.opts.JSON && proc.isStopping() {
+			return
+		}
+
+		if err := w.handle(r, err); err != nil {
+			w.fatal <- err
+			return
+		}
+
+		if proc.isStopping() {
+			return
+		}
+
+		if isFailure(err) {
+			w.reportFailure(r)
+		}
+
+		w.hook(ctx, r, err)
+		if w.failsFast(r, err) {
+			w.fatal <- err
+		}
+	}()
+
+	w.running = proc
+	return nil
+}
+
+// executeOnce runs the commands for the given change, retrying them as many
+// times as allowed, waiting for them even in restart mode, and returns the
+// error they have last failed with, unless ctx is done first.
+func (w *Watcher) executeOnce(ctx context.Context, ch change) error {
+	w.stop()
+
+	r := w.newRun(ch)
+	if w.onChange(r) {
+		return nil
+	}
+
+	for ; ; r = r.retry() {
+		err := w.executeSync(ctx, r)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err := w.handle(r, err); err != nil {
+			return err
+		}
+
+		w.hook(ctx, r, err)
+
+		if !isFailure(err) || r.attempt >= w.opts.Retry {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff(r.attempt + 1)):
+		}
+	}
+}
+
+// executeSync runs the commands for r and waits for them.
+func (w *Watcher) executeSync(ctx context.Context, r *run) error {
+	w.banner(r)
+
+	proc := &process{done: make(chan struct{})}
+	defer close(proc.done)
+	defer context.AfterFunc(ctx, func() { proc.interrupt(signalOf(ctx)) })()
+
+	return w.execute(r, proc)
+}
+
+func (w *Watcher) newRun(ch change) *run {
+	if ch.kind != changeNone {
+		w.changes++
+	}
+
+	return &run{change: ch, time: time.Now(), count: w.changes}
+}
+
+// onChange calls the OnChange hook for r, if given, and reports whether it is
+// all there is to do, there being no commands to run.
+func (w *Watcher) onChange(r *run) bool {
+	if w.opts.OnChange != nil {
+		w.opts.OnChange(r.Event())
+	}
+
+	return len(w.opts.Exec) == 0 && w.opts.Run == nil
+}
+
+// retry returns the next attempt at the run.
+func (r *run) retry() *run {
+	return &run{change: r.change, time: time.Now(), count: r.count, attempt: r.attempt + 1}
+}
+
+// reportFailure hands r over to Run, replacing any failure not yet taken.
+func (w *Watcher) reportFailure(r *run) {
+	if w.opts.Retry == 0 {
+		return
+	}
+
+	select {
+	case <-w.failed:
+	default:
+	}
+
+	w.failed <- r
+}
+
+// hook runs the OnSuccess or the OnFail command, as the outcome of r says,
+// warning about its failure rather than stopping the watcher.
+func (w *Watcher) hook(ctx context.Context, r *run, outcome error) {
+	name, hook := "success", w.opts.OnSuccess
+	switch {
+	case isFailure(outcome):
+		name, hook = "failure", w.opts.OnFail
+	case outcome != nil:
+		return
+	}
+
+	if hook == "" {
+		return
+	}
+
+	args := []string{hook}
+	if w.opts.NoShell {
+		args = strings.Fields(hook)
+	}
+
+	cmd, err := w.command(ctx, r, args)
+	if err != nil {
+		w.warn(fmt.Sprintf("the %s hook has failed: %s", name, err))
+		return
+	}
+
+	cmd.Env = append(cmd.Env, "WATCHER_EXIT_CODE="+strconv.Itoa(exitCodeOf(outcome)))
+	cmd.Stdin = nil
+	cmd.Stdout, cmd.Stderr = w.opts.Stderr, w.opts.Stderr
+
+	if w.opts.DryRun {
+		w.dryRun(r, cmd)
+		return
+	}
+
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		w.warn(fmt.Sprintf("the %s hook has failed: %s", name, err))
+	}
+}
+
+// exitCodeOf returns the exit code of the command that has ended with err, or
+// -1 if it has not exited on its own, as when it has timed out.
+func exitCodeOf(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	case err != nil:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// failsFast reports whether Run is to return for the commands having failed
+// with err for r, as for FailFast, there being no retries left.
+func (w *Watcher) failsFast(r *run, err error) bool {
+	return w.opts.FailFast && isFailure(err) && r.attempt >= w.opts.Retry
+}
+
+// isFailure reports whether err is one of the failures worth retrying for,
+// which are the commands exiting unsuccessfully or timing out, and the Run
+// function returning an error.
+func isFailure(err error) bool {
+	var exitErr *exec.ExitError
+	var timeoutErr *timeoutError
+	var funcErr *funcError
+
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode() != 0
+	case errors.As(err, &timeoutErr):
+		return true
+	case errors.As(err, &funcErr):
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns how long to wait before the given attempt, doubling with
+// every attempt up to MaxRetryBackoff.
+func backoff(attempt int) time.Duration {
+	d := RetryBackoff
+	for range attempt - 1 {
+		if d *= 2; d >= MaxRetryBackoff {
+			return MaxRetryBackoff
+		}
+	}
+
+	return d
+}
+
+func (w *Watcher) banner(r *run) {
+	if !w.logs(LevelInfo) {
+		return
+	}
+
+	width := w.width()
+
+	clearing := !w.opts.NoClear && !(w.opts.KeepOnFailure && w.failing.Load())
+
+	switch {
+	case clearing:
+		fmt.Fprint(w.opts.Stderr, ansi.ClearScreen+ansi.MoveHome)
+	case r.change.kind != changeNone:
+		rule := 40
+		if width > 0 {
+			rule = min(rule, width)
+		}
+
+		fmt.Fprintln(w.opts.Stderr, ansi.Gray(strings.Repeat("-", rule)))
+	}
+
+	var notes string
+	if r.attempt > 0 {
+		notes += " " + ansi.Gray(fmt.Sprintf("(retry %d/%d)", r.attempt, w.opts.Retry))
+	}
+
+	if w.opts.DryRun {
+		notes += " " + ansi.Gray("(dry run)")
+	}
+
+	line := fmt.Sprintf("[%s] %s%s%s", ansi.Gray(r.time.Format(time.DateTime)), w.prefix(), r.change, notes)
+	if w.opts.BannerFormat != "" {
+		line = bannerReplacer(r, w.opts.Name).Replace(w.opts.BannerFormat) + notes
+	}
+
+	fmt.Fprintf(w.opts.Stderr, "%s\n\n", ansi.Wrap(line, width))
+}
+
+// bannerTokens are the tokens a BannerFormat may hold.
+var bannerTokens = []string{"{time}", "{file}", "{count}", "{event}", "{change}", "{task}", "{retry}"}
+
+// bannerReplacer replaces the tokens of a BannerFormat with what they stand
+// for in r, in the order of bannerTokens.
+func bannerReplacer(r *run, task string) *strings.Replacer {
+	values := []string{
+		r.time.Format(time.DateTime),
+		r.change.path,
+		strconv.Itoa(r.count),
+		r.change.event(),
+		r.change.String(),
+		task,
+		strconv.Itoa(r.attempt),
+	}
+
+	var oldnew []string
+	for i, token := range bannerTokens {
+		oldnew = append(oldnew, token, values[i])
+	}
+
+	return strings.NewReplacer(oldnew...)
+}
+
+// validateBannerFormat reports the first token of format, enclosed in braces,
+// that is not one of bannerTokens.
+func validateBannerFormat(format string) error {
+	for rest := format; ; {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			return nil
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil
+		}
+
+		token := rest[start : start+end+1]
+		if !slices.Contains(bannerTokens, token) {
+			return errUnknownBannerToken(token)
+		}
+
+		rest = rest[start+end+1:]
+	}
+}
+
+// prefix returns what the lines the Watcher reports about its runs begin with,
+// its name, if it has one.
+func (w *Watcher) prefix() string {
+	if w.opts.Name == "" {
+		return ""
+	}
+
+	return w.opts.Name + ": "
+}
+
+// width returns the number of columns of the terminal Stderr is, or zero if
+// it is not one. It is taken anew every time, as the terminal may have been
+// resized.
+func (w *Watcher) width() int {
+	file, ok := w.opts.Stderr.(*os.File)
+	if !ok {
+		return 0
+	}
+
+	cols, _, err := ansi.TerminalSize(file.Fd())
+	if err != nil {
+		return 0
+	}
+
+	return cols
+}
+
+func (w *Watcher) heartbeat() {
+	if !w.logs(LevelInfo) || w.opts.NoHeartbeat || w.previewing {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(w.beatenAt) < w.opts.HeartbeatInterval {
+		return
+	}
+
+	w.beatenAt = now
+	fmt.Fprintf(w.opts.Stderr, "[%s]\r", ansi.Gray(now.Format(time.DateTime)))
+}
+
+// preview shows the paths in ch and how long is left until deadline, when
+// the commands are run, cut to the width of the terminal.
+func (w *Watcher) preview(ch change, deadline time.Time) {
+	if !w.logs(LevelInfo) {
+		return
+	}
+
+	files := ch.files
+	if len(files) == 0 && ch.path != "" {
+		files = []string{ch.path}
+	}
+
+	left := max(time.Until(deadline), 0).Round(Granularity)
+	line := fmt.Sprintf("[%s] %d changed, running in %s: %s",
+		time.Now().Format(time.DateTime), len(files), left, strings.Join(files, ", "))
+
+	if width := w.width(); width > 0 {
+		line = truncate(line, width-1)
+	}
+
+	w.previewing = true
+	fmt.Fprint(w.opts.Stderr, ansi.ClearLine+ansi.Gray(line))
+}
+
+// endPreview clears the line of the preview, if it is being shown.
+func (w *Watcher) endPreview() {
+	if !w.previewing {
+		return
+	}
+
+	w.previewing = false
+	fmt.Fprint(w.opts.Stderr, ansi.ClearLine)
+}
+
+// truncate cuts s to at most width runes, ending it with an ellipsis if cut.
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+
+	if width < 1 {
+		return ""
+	}
+
+	return string(runes[:width-1]) + "…"
+}
+
+func (w *Watcher) warn(msg string) {
+	if w.opts.JSON {
+		w.encoder.encode(event{Event: "warning", Time: time.Now(), Error: msg})
+		return
+	}
+
+	if !w.logs(LevelWarn) {
+		return
+	}
+
+	fmt.Fprintf(w.opts.Stderr, "%s\n\n", msg)
+}
+
+// pause tells whether the watching has been paused or resumed.
+func (w *Watcher) pause(paused bool) {
+	if !w.logs(LevelInfo) {
+		return
+	}
+
+	msg := "resumed, press space to pause"
+	if paused {
+		msg = "paused, press space to resume"
+	}
+
+	fmt.Fprintf(w.opts.Stderr, "%s%s\n", ansi.Gray(msg), strings.Repeat(" ", 10))
+}
+
+// debug prints a diagnostic line at the debug level, which is left out of the
+// JSON output.
+func (w *Watcher) debug(format string, args ...any) {
+	if !w.logs(LevelDebug) {
+		return
+	}
+
+	fmt.Fprintln(w.opts.Stderr, ansi.Gray(fmt.Sprintf(format, args...)))
+}
+
+// ignored reports that path has been skipped for the given rule, every time
+// in debug mode, or the first time in dry run mode, so that what is watched
+// over can be checked along with the commands.
+func (w *Watcher) ignored(path, rule string) {
+	if w.opts.DryRun && !w.logs(LevelDebug) {
+		if _, reported := w.reported.LoadOrStore(path, true); !reported && w.logs(LevelInfo) {
+			fmt.Fprintln(w.opts.Stderr, ansi.Gray(fmt.Sprintf("skipped %s, ignored by %s", path, rule)))
+		}
+		return
+	}
+
+	w.debug("skipped %s, ignored by %s", path, rule)
+}
+
+func (w *Watcher) fail(err error) error {
+	if w.opts.JSON {
+		w.encoder.encode(event{Event: "error", Time: time.Now(), Error: err.Error()})
+		return err
+	}
+
+	fmt.Fprintln(w.opts.Stderr, err)
+	return err
+}
+
+// dryRun records cmd as the command that would have been run.
+func (w *Watcher) dryRun(r *run, cmd *exec.Cmd) {
+	r.commands = append(r.commands, cmd.String())
+	if w.opts.JSON {
+		return
+	}
+
+	fmt.Fprintf(w.opts.Stderr, "would run: %s\n", cmd)
+}
+
+func (w *Watcher) step(i int, args []string) {
+	if !w.logs(LevelInfo) || len(w.opts.Exec) == 1 {
+		return
+	}
+
+	fmt.Fprintln(w.opts.Stderr, ansi.Gray(fmt.Sprintf("[%d/%d] %s", i+1, len(w.opts.Exec), strings.Join(args, " "))))
+}
+
+// handle reports the outcome of a run, returning the errors that should stop
+// the watcher.
+func (w *Watcher) handle(r *run, err error) error {
+	w.failing.Store(isFailure(err))
+	w.stats.record(r, err)
+
+	if w.opts.JSON {
+		return w.handleJSON(r, err)
+	}
+
+	switch err := err.(type) {
+
+	case *unsupportedOSError:
+		return w.fail(err)
+
+	case *startProcessFailureError:
+		return w.fail(err)
+	}
+
+	if !w.logs(LevelInfo) {
+		return nil
+	}
+
+	duration := roundDuration(r.duration)
+
+	switch err := err.(type) {
+
+	case nil:
+		if !w.opts.DryRun {
+			fmt.Fprintf(w.opts.Stderr, "\n%s%s\n", w.prefix(), ansi.Gray("done in "+duration.String()))
+		}
+
+	case *timeoutError:
+		fmt.Fprintf(w.opts.Stderr, "\n%s%s\n", w.prefix(), ansi.Red(err.Error()))
+
+	case *exec.ExitError:
+		if code := err.ExitCode(); code != 0 {
+			fmt.Fprintf(w.opts.Stderr, "\n%sexited with code %s %s\n", w.prefix(), ansi.Yellow(strconv.Itoa(code)), ansi.Gray("in "+duration.String()))
+		}
+
+	case *funcError:
+		fmt.Fprintf(w.opts.Stderr, "\n%sfailed: %s %s\n", w.prefix(), ansi.Red(err.Error()), ansi.Gray("in "+duration.String()))
+	}
+
+	fmt.Fprint(w.opts.Stderr, "\n")
+	return nil
+}
+
+// roundDuration rounds d to a precision fit for reading, hundredths of a
+// second from a second on, and milliseconds below that.
+func roundDuration(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(10 * time.Millisecond)
+	}
+
+	return d.Round(time.Millisecond)
+}
+
+func (w *Watcher) usesPlaceholder() bool {
+	for _, args := range w.opts.Exec {
+		for _, arg := range args {
+			if strings.Contains(arg, Placeholder) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// substitute replaces the placeholder in args with filename, quoted so that
+// the shell takes it as a single argument.
+func substitute(args []string, filename string, quote func(string) string) []string {
+	quoted := quote(filename)
+
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = strings.ReplaceAll(arg, Placeholder, quoted)
+	}
+
+	return result
+}
+
+func quoteSh(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteCmd quotes s for cmd, which still expands variables such as %PATH%
+// within double quotes, so every % is left out of them and escaped with a
+// caret, as in "100"^%"PATH"^%".txt".
+func quoteCmd(s string) string {
+	return `"` + strings.ReplaceAll(s, "%", `"^%"`) + `"`
+}
+
+func quoteNone(s string) string {
+	return s
+}
+
+// shell returns the shell the commands are run through, and whether it takes
+// the commands the way cmd does, rather than the way sh does.
+func (w *Watcher) shell() (string, bool, error) {
+	if w.opts.Shell != "" {
+		base := strings.ToLower(filepath.Base(w.opts.Shell))
+		return w.opts.Shell, base == "cmd" || base == "cmd.exe", nil
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		return "cmd", true, nil
+	case "darwin", "linux":
+		return "/bin/sh", false, nil
+	default:
+		return "", false, errUnsupportedOS(runtime.GOOS)
+	}
+}
+
+// command creates the command for args, which is terminated once ctx is done,
+// and killed if it has not exited after the grace period.
+func (w *Watcher) command(ctx context.Context, r *run, args []string) (*exec.Cmd, error) {
+	if len(args) == 0 {
+		return nil, ErrNoCommand
+	}
+
+	var cmd *exec.Cmd
+
+	if w.opts.NoShell {
+		args = substitute(args, r.change.path, quoteNone)
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	} else {
+		shell, cmdLike, err := w.shell()
+		if err != nil {
+			return nil, err
+		}
+
+		if cmdLike {
+			args = substitute(args, r.change.path, quoteCmd)
+			cmd = exec.CommandContext(ctx, shell, append([]string{"/c"}, args...)...)
+		} else {
+			args = substitute(args, r.change.path, quoteSh)
+
+			// a command beginning with a dash, as one after -e --, would
+			// otherwise be taken by the shell as one of its options
+			script := strings.Join(args, " ")
+			if strings.HasPrefix(script, "-") {
+				cmd = exec.CommandContext(ctx, shell, "-c", "--", script)
+			} else {
+				cmd = exec.CommandContext(ctx, shell, "-c", script)
+			}
+		}
+	}
+
+	cmd.Cancel = func() error {
+		if err := terminate(cmd, signalOf(ctx)); err != nil {
+			return kill(cmd)
+		}
+
+		return nil
+	}
+	cmd.WaitDelay = GracePeriod
+	cmd.Dir = w.opts.Dir
+	if w.opts.CwdChanged && r.change.path != "" {
+		dir := filepath.Dir(r.change.path)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			cmd.Dir = dir
+		}
+	}
+
+	files := r.change.files
+	if len(files) == 0 && r.change.path != "" {
+		files = []string{r.change.path}
+	}
+
+	cmd.Env = append(os.Environ(),
+		"WATCHER_CHANGE_COUNT="+strconv.Itoa(r.count),
+		"WATCHER_CHANGED_FILE="+r.change.path,
+		"WATCHER_CHANGED_FILES="+strings.Join(files, "\n"),
+	)
+	cmd.Env = append(cmd.Env, w.opts.Env...)
+
+	switch {
+	case r.change.appended != nil:
+		cmd.Stdin = bytes.NewReader(r.change.appended)
+	case !w.opts.TriggerStdin && !w.opts.Interactive:
+		cmd.Stdin = w.opts.Stdin
+	}
+	cmd.Stdout = w.opts.Stdout
+	cmd.Stderr = w.opts.Stderr
+
+	if w.opts.JSONCapture {
+		cmd.Stdout = &r.stdout
+		cmd.Stderr = &r.stderr
+	}
+
+	switch {
+	case w.opts.MergeStderr:
+		cmd.Stderr = cmd.Stdout
+	case w.opts.QuietStderr:
+		cmd.Stderr = nil
+	}
+
+	// commands that may be terminated are given a process group of their own,
+	// so that the processes they start are terminated along with them
+	if w.opts.Restart || w.opts.Timeout != 0 {
+		setProcessGroup(cmd)
+	}
+
+	return cmd, nil
+}
+
+// execute runs the commands in order, waiting for each to finish and stopping
+// at the first one that fails.
+func (w *Watcher) execute(r *run, proc *process) error {
+	start := time.Now()
+	defer func() { r.duration = time.Since(start) }()
+
+	for i, args := range w.opts.Exec {
+		w.step(i, args)
+
+		err := w.executeStep(r, proc, args)
+		if w.opts.OnExec != nil && !w.opts.DryRun && !errors.Is(err, errProcessStopped) {
+			w.opts.OnExec(slices.Clone(args), exitCodeOf(err))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.opts.Run != nil {
+		return w.executeFunc(r, proc)
+	}
+
+	return nil
+}
+
+// executeStep runs a single command, terminating it if it outlives the
+// timeout.
+func (w *Watcher) executeStep(r *run, proc *process, args []string) error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if w.opts.Timeout != 0 {
+		ctx, cancel = context.WithTimeout(ctx, w.opts.Timeout)
+	}
+	defer cancel()
+
+	cmd, err := w.command(ctx, r, args)
+	if err != nil {
+		return err
+	}
+
+	if w.opts.DryRun {
+		w.dryRun(r, cmd)
+		return nil
+	}
+
+	if w.opts.PTY {
+		t, err := w.attachPTY(cmd)
+		if err != nil {
+			return &startProcessFailureError{err}
+		}
+		defer t.close()
+
+		if err := proc.start(cmd); err != nil {
+			return err
+		}
+		t.started()
+	} else if err := proc.start(cmd); err != nil {
+		return err
+	}
+
+	err = cmd.Wait()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return errTimedOut(w.opts.Timeout)
+	}
+
+	return err
+}
+
+// executeFunc runs the Run option as the last step, its context being canceled
+// once the process is stopped, or once it times out.
+func (w *Watcher) executeFunc(r *run, proc *process) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if w.opts.Timeout != 0 {
+		ctx, cancel = context.WithTimeout(ctx, w.opts.Timeout)
+	}
+	defer cancel()
+
+	if w.opts.DryRun {
+		return nil
+	}
+
+	if err := proc.startFunc(cancel); err != nil {
+		return err
+	}
+
+	err := w.opts.Run(ctx, r.Event())
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() == context.DeadlineExceeded:
+		return errTimedOut(w.opts.Timeout)
+	default:
+		return &funcError{err}
+	}
+}
+
+// stop stops the command left running in restart mode.
+func (w *Watcher) stop() {
+	if w.running == nil {
+		return
+	}
+
+	w.running.stop()
+	w.running = nil
+}
+
+// start starts cmd as the current step, unless the process has been stopped.
+func (proc *process) start(cmd *exec.Cmd) error {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+
+	if proc.stopping {
+		return errProcessStopped
+	}
+
+	if err := cmd.Start(); err != nil {
+		return &startProcessFailureError{err}
+	}
+
+	proc.cmd = cmd
+	return nil
+}
+
+// startFunc sets the Run option as the current step, to be canceled through
+// cancel, unless the process has been stopped.
+func (proc *process) startFunc(cancel context.CancelFunc) error {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+
+	if proc.stopping {
+		return errProcessStopped
+	}
+
+	proc.cmd, proc.cancel = nil, cancel
+	return nil
+}
+
+// stop prevents further steps from starting and terminates the current one,
+// killing it if it has not exited after the grace period. It returns once the
+// whole chain has finished.
+func (proc *process) stop() {
+	proc.interrupt(nil)
+}
+
+// interrupt stops the process as stop does, sending sig to the current step
+// rather than the usual signal, if given. Only the first call signals it, the
+// others wait for it as well.
+func (proc *process) interrupt(sig os.Signal) {
+	proc.mu.Lock()
+	stopped := proc.stopping
+	proc.stopping = true
+	cmd, cancel := proc.cmd, proc.cancel
+	proc.mu.Unlock()
+
+	// the Run option cannot be killed, so it is waited for however long it
+	// takes to return
+	if cancel != nil && !stopped {
+		cancel()
+	}
+
+	if stopped || cmd == nil {
+		<-proc.done
+		return
+	}
+
+	if err := terminate(cmd, sig); err != nil {
+		kill(cmd)
+	}
+
+	select {
+	case <-proc.done:
+	case <-time.After(GracePeriod):
+		kill(cmd)
+		<-proc.done
+	}
+}
+
+func (proc *process) isStopping() bool {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+
+	return proc.stopping
+}
@@ -0,0 +1,298 @@
+package watcher
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alan-b-lima/watcher/ansi-escape"
+)
+
+func TestUnsupportedOSFails(t *testing.T) {
+	w, _ := newTestWatcher(t, Options{Exec: [][]string{{"true"}}})
+
+	err := w.handle(w.newRun(change{}), errUnsupportedOS("plan9"))
+	if !errors.Is(err, ErrUnsupportedOS) {
+		t.Fatalf("got %v, want %v", err, ErrUnsupportedOS)
+	}
+}
+
+func TestMissingBinaryFails(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	w, _ := newTestWatcher(t, Options{Exec: [][]string{{missing}}, NoShell: true})
+
+	var startErr *startProcessFailureError
+	if err := runOnce(t, w); !errors.As(err, &startErr) {
+		t.Fatalf("got %v, want a failure to start the process", err)
+	}
+}
+
+func TestDryRunStartsNothing(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	w, out := newTestWatcher(t, Options{Exec: [][]string{{"echo ran > " + marker}}, DryRun: true})
+
+	if err := runOnce(t, w); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(marker); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("the command has run: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "would run: ") || !strings.Contains(out.String(), marker) {
+		t.Errorf("the command is not printed: %q", out)
+	}
+}
+
+func TestChangeCountEnv(t *testing.T) {
+	requireSh(t)
+
+	dir := t.TempDir()
+	w, out := newTestWatcher(t, Options{
+		Watch:     []string{dir},
+		TickSpeed: MinTickSpeed,
+		Exec:      [][]string{{"echo count=$WATCHER_CHANGE_COUNT"}},
+	})
+
+	runAsync(t, w)
+	waitFor(t, out, "count=0")
+
+	writeTree(t, dir, "a.txt")
+	waitFor(t, out, "count=1")
+
+	writeTree(t, dir, "b.txt")
+	waitFor(t, out, "count=2")
+}
+
+func TestDurationInEvents(t *testing.T) {
+	requireSh(t)
+
+	w, out := newTestWatcher(t, Options{
+		JSON: true,
+		Exec: [][]string{{"sleep 0.05"}},
+	})
+
+	if err := runOnce(t, w); err != nil {
+		t.Fatal(err)
+	}
+
+	var ev event
+	if err := json.NewDecoder(strings.NewReader(out.String())).Decode(&ev); err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.DurationMs == nil {
+		t.Fatalf("got no duration in %q", out)
+	}
+
+	if *ev.DurationMs < 50 {
+		t.Errorf("got a duration of %dms, want at least 50ms", *ev.DurationMs)
+	}
+}
+
+func TestChangesDuringFirstExecution(t *testing.T) {
+	requireSh(t)
+
+	for _, notify := range []bool{false, true} {
+		dir := t.TempDir()
+		edit := `if [ "$WATCHER_CHANGE_COUNT" = 0 ]; then echo a > a.txt; echo b > b.txt; sleep 0.1; fi`
+
+		w, out := newTestWatcher(t, Options{
+			Watch:     []string{dir},
+			Dir:       dir,
+			TickSpeed: MinTickSpeed,
+			Notify:    notify,
+			Exec:      [][]string{{edit}, {"echo count=$WATCHER_CHANGE_COUNT"}},
+		})
+
+		result := runAsync(t, w)
+		waitFor(t, out, "count=1")
+
+		time.Sleep(20 * MinTickSpeed)
+		if strings.Contains(out.String(), "count=2") {
+			t.Errorf("notify %t: the changes have been counted more than once: %q", notify, out)
+		}
+
+		select {
+		case err := <-result:
+			t.Errorf("notify %t: the watcher has stopped: %v", notify, err)
+		default:
+		}
+	}
+}
+
+func TestKeepOnFailureClear(t *testing.T) {
+	requireSh(t)
+
+	tests := []struct {
+		keep, failed bool
+		want         bool
+	}{
+		{false, false, true},
+		{false, true, true},
+		{true, false, true},
+		{true, true, false},
+	}
+
+	for _, tt := range tests {
+		w, out := newTestWatcher(t, Options{KeepOnFailure: tt.keep, Exec: [][]string{{"true"}}})
+		w.opts.NoClear = false
+
+		var err error
+		if tt.failed {
+			err = exec.Command("false").Run()
+		}
+
+		w.handle(w.newRun(change{}), err)
+		before := len(out.String())
+
+		w.banner(w.newRun(change{kind: changeModified, path: "a.txt"}))
+		if got := strings.Contains(out.String()[before:], ansi.ClearScreen); got != tt.want {
+			t.Errorf("keep %t, failed %t: got clearing %t, want %t", tt.keep, tt.failed, got, tt.want)
+		}
+	}
+}
+
+func TestStderrDestinations(t *testing.T) {
+	requireSh(t)
+
+	tests := []struct {
+		name               string
+		merge, quiet       bool
+		wantOut, wantErr   bool
+		wantOutHoldsStderr bool
+	}{
+		{"separate", false, false, true, true, false},
+		{"merge", true, false, true, false, true},
+		{"quiet", false, true, true, false, false},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr lockedBuffer
+		w, _ := newTestWatcher(t, Options{
+			Stdout:      &stdout,
+			Stderr:      &stderr,
+			MergeStderr: tt.merge,
+			QuietStderr: tt.quiet,
+			Exec:        [][]string{{"echo to-stdout; echo to-stderr >&2"}},
+		})
+
+		if err := runOnce(t, w); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := strings.Contains(stdout.String(), "to-stdout"); got != tt.wantOut {
+			t.Errorf("%s: got the standard output on stdout %t, want %t", tt.name, got, tt.wantOut)
+		}
+
+		if got := strings.Contains(stderr.String(), "to-stderr"); got != tt.wantErr {
+			t.Errorf("%s: got the standard error on stderr %t, want %t", tt.name, got, tt.wantErr)
+		}
+
+		if got := strings.Contains(stdout.String(), "to-stderr"); got != tt.wantOutHoldsStderr {
+			t.Errorf("%s: got the standard error on stdout %t, want %t", tt.name, got, tt.wantOutHoldsStderr)
+		}
+	}
+
+	_, err := New(Options{Watch: []string{t.TempDir()}, MergeStderr: true, QuietStderr: true, Exec: [][]string{{"true"}}})
+	if !errors.Is(err, errMergeQuietStderr) {
+		t.Errorf("got %v, want %v", err, errMergeQuietStderr)
+	}
+}
+
+func TestBannerFormat(t *testing.T) {
+	w, out := newTestWatcher(t, Options{
+		Name:         "build",
+		BannerFormat: "{task}: {event} {file} #{count} (retry {retry})",
+		Retry:        2,
+		Exec:         [][]string{{"true"}},
+	})
+
+	r := w.newRun(change{kind: changeModified, path: "a.go"})
+	w.banner(r)
+	w.banner(r.retry())
+
+	for _, want := range []string{"build: change a.go #1 (retry 0)\n", "build: change a.go #1 (retry 1) ", "(retry 1/2)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%q is missing from %q", want, out)
+		}
+	}
+
+	for _, format := range []string{"{time} {nope}", "{Time}"} {
+		_, err := New(Options{Watch: []string{t.TempDir()}, BannerFormat: format, Exec: [][]string{{"true"}}})
+		if err == nil {
+			t.Errorf("%q: got no error", format)
+		}
+	}
+
+	if _, err := New(Options{Watch: []string{t.TempDir()}, BannerFormat: "{ not a token", Exec: [][]string{{"true"}}}); err != nil {
+		t.Errorf("an unclosed brace: %v", err)
+	}
+}
+
+func TestCwdChanged(t *testing.T) {
+	requireSh(t)
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, dir, "sub/")
+
+	w, out := newTestWatcher(t, Options{
+		Watch:      []string{dir},
+		Dir:        dir,
+		CwdChanged: true,
+		TickSpeed:  MinTickSpeed,
+		Count:      1,
+		Exec:       [][]string{{"pwd -P"}},
+	})
+
+	result := runAsync(t, w)
+	waitFor(t, out, dir+"\n")
+
+	writeTree(t, dir, "sub/a.txt")
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for line := range strings.Lines(out.String()) {
+		if strings.HasPrefix(line, dir) {
+			lines = append(lines, line)
+		}
+	}
+
+	if want := []string{dir + "\n", filepath.Join(dir, "sub") + "\n"}; !slices.Equal(lines, want) {
+		t.Errorf("got %q as the directories, want %q", lines, want)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		quote   func(string) string
+		s, want string
+	}{
+		{quoteSh, "a.txt", `'a.txt'`},
+		{quoteSh, "a b.txt", `'a b.txt'`},
+		{quoteSh, "it's.txt", `'it'\''s.txt'`},
+		{quoteSh, "$HOME.txt", `'$HOME.txt'`},
+		{quoteCmd, "a.txt", `"a.txt"`},
+		{quoteCmd, `C:\a b.txt`, `"C:\a b.txt"`},
+		{quoteCmd, "100%PATH%.txt", `"100"^%"PATH"^%".txt"`},
+		{quoteCmd, "50%", `"50"^%""`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.quote(tt.s); got != tt.want {
+			t.Errorf("quoting %q: got %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// hashCache keeps the hashes of the files across scans, so that only the
+// files whose size or mod time have changed are read again.
+type hashCache struct {
+	entries map[string]hashEntry
+
+	// hits and misses count the lookups since the last reset.
+	hits, misses int
+}
+
+// hashEntry is the hash of a file as of when it had the given size and mod
+// time, which is taken to still hold while they do.
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	sum     [sha256.Size]byte
+}
+
+// sum returns the hash of the contents of the file at path, only reading it
+// if its size or mod time have changed since it was last hashed.
+func (c *hashCache) sum(path string, info fs.FileInfo) ([sha256.Size]byte, error) {
+	entry, ok := c.entries[path]
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		c.hits++
+		return entry.sum, nil
+	}
+	c.misses++
+
+	file, err := os.Open(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	entry = hashEntry{size: info.Size(), modTime: info.ModTime()}
+	h.Sum(entry.sum[:0])
+
+	if c.entries == nil {
+		c.entries = make(map[string]hashEntry)
+	}
+
+	c.entries[path] = entry
+	return entry.sum, nil
+}
+
+// prune drops the entries of the files no longer in the snapshot, and resets
+// the counts.
+func (c *hashCache) prune(snap snapshot) {
+	for path := range c.entries {
+		if _, ok := snap.files[path]; !ok {
+			delete(c.entries, path)
+		}
+	}
+
+	c.hits, c.misses = 0, 0
+}
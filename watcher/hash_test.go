@@ -0,0 +1,86 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHashIgnoresModTime(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt")
+	path := filepath.Join(dir, "a.txt")
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}, Hash: true})
+	if err := w.Prime(); err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changes.Empty() {
+		t.Errorf("got %+v for a file only touched", changes)
+	}
+
+	if err := os.WriteFile(path, []byte("b.txt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(changes.Modified, []string{path}) {
+		t.Errorf("got %+v, want %s modified", changes, path)
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	dir := b.TempDir()
+	writeSyntheticTree(b, dir, 20, 100, 4<<10)
+
+	b.Run("modtime", func(b *testing.B) {
+		w, _ := newTestWatcher(b, Options{Watch: []string{dir}})
+
+		for b.Loop() {
+			if _, err := w.Scan(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("hash", func(b *testing.B) {
+		w, _ := newTestWatcher(b, Options{Watch: []string{dir}, Hash: true})
+
+		for b.Loop() {
+			w.hashes = hashCache{}
+			if _, err := w.Scan(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("hash-cached", func(b *testing.B) {
+		w, _ := newTestWatcher(b, Options{Watch: []string{dir}, Hash: true})
+		if err := w.Prime(); err != nil {
+			b.Fatal(err)
+		}
+
+		for b.Loop() {
+			if _, err := w.Scan(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
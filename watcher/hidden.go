@@ -0,0 +1,14 @@
+//go:build !windows
+
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// isHidden reports whether the base name of path begins with a dot.
+func isHidden(path string, _ fs.FileInfo) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
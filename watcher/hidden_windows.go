@@ -0,0 +1,21 @@
+//go:build windows
+
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// isHidden reports whether the base name of path begins with a dot, or the
+// file has the hidden attribute.
+func isHidden(path string, info fs.FileInfo) bool {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return true
+	}
+
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	return ok && attrs.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
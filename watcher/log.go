@@ -0,0 +1,44 @@
+package watcher
+
+// LogLevel is how much of its own output the watcher reports, every level
+// reporting what the ones above it do. The output of the commands is never
+// affected.
+type LogLevel int
+
+const (
+	// LevelDebug adds how long each scan took, each path ignored and each
+	// change detected.
+	LevelDebug LogLevel = iota - 1
+
+	// LevelInfo reports the banners, the heartbeat and the outcome of each
+	// run, and is the default.
+	LevelInfo
+
+	// LevelWarn reports only the warnings, such as falling back to polling,
+	// and the errors.
+	LevelWarn
+
+	// LevelError reports only the errors that stop Run.
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// logs reports whether output of the given level is printed, which it never
+// is in JSON mode.
+func (w *Watcher) logs(level LogLevel) bool {
+	return !w.opts.JSON && level >= w.opts.LogLevel
+}
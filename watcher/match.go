@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// matchPath reports whether name, a slash separated path, matches pattern
+// segment by segment, where a "**" segment matches any number of segments.
+func matchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchPattern matches the way ignore files do: a pattern without slashes is
+// matched against the base name only, otherwise as matchPath does.
+func matchPattern(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		match, _ := path.Match(pattern, path.Base(name))
+		return match
+	}
+
+	return matchPath(pattern, name)
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			for i := range len(name) + 1 {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if match, _ := path.Match(pattern[0], name[0]); !match {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// validatePattern reports whether any segment of pattern is malformed.
+func validatePattern(pattern string) error {
+	for segment := range strings.SplitSeq(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasMeta reports whether path holds any of the characters patterns treat
+// specially.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// glob returns the paths matching pattern, which may use "**" as matchPath
+// does. A path that exists as it is given is taken literally, as with names
+// such as a[1].txt. Otherwise, the directory leading up to the first segment
+// with a pattern in it is walked over in search of matches, without going into
+// the directories that cannot lead to any.
+func glob(pattern string) ([]string, error) {
+	if _, err := os.Lstat(pattern); err == nil {
+		return []string{filepath.Clean(pattern)}, nil
+	}
+
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	if err := validatePattern(pattern); err != nil {
+		return nil, err
+	}
+
+	segments := strings.Split(pattern, "/")
+	i := slices.IndexFunc(segments, hasMeta)
+	if i < 0 {
+		return []string{filepath.FromSlash(pattern)}, nil
+	}
+
+	base, rest := strings.Join(segments[:i], "/"), strings.Join(segments[i:], "/")
+	switch {
+	case i == 0:
+		base = "."
+	case base == "", strings.HasSuffix(base, ":"):
+		// the root of the file system, or that of a volume on Windows
+		base += "/"
+	}
+
+	var matches []string
+	err := filepath.WalkDir(filepath.FromSlash(base), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == filepath.FromSlash(base) && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipAll
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(filepath.FromSlash(base), path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		if matchPath(rest, filepath.ToSlash(rel)) {
+			matches = append(matches, path)
+		}
+
+		if d.IsDir() && !leadsToMatch(segments[i:], strings.Split(filepath.ToSlash(rel), "/")) {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+// leadsToMatch reports whether the paths under the directory whose segments
+// are given may match the pattern, also given in segments, which is the case
+// while the directory matches as many of its leading segments, and always past
+// a "**".
+func leadsToMatch(pattern, dir []string) bool {
+	for i, segment := range dir {
+		if i >= len(pattern) {
+			return false
+		}
+
+		if pattern[i] == "**" {
+			return true
+		}
+
+		if match, _ := path.Match(pattern[i], segment); !match {
+			return false
+		}
+	}
+
+	return len(dir) < len(pattern)
+}
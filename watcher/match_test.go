@@ -0,0 +1,113 @@
+package watcher
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**/*.tmp", "a.tmp", true},
+		{"**/*.tmp", "x/y/a.tmp", true},
+		{"**/*.tmp", "x/a.tmp.go", false},
+		{"node_modules", "node_modules", true},
+		{"node_modules", "web/node_modules", false},
+		{"**/node_modules", "web/node_modules", true},
+		{"src/generated", "src/generated", true},
+		{"src/generated", "src/generated.go", false},
+		{"src/generated", "lib/src/generated", false},
+		{"src/*/gen", "src/a/gen", true},
+		{"src/*/gen", "src/a/b/gen", false},
+		{"src/**/gen", "src/gen", true},
+		{"src/**/gen", "src/a/b/gen", true},
+		{"test", "test", true},
+		{"test", "latest", false},
+		{"test", "mytest", false},
+		{"**/test", "src/test", true},
+		{"**/test", "src/latest", false},
+		{"**/test", "src/test.go", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %t, want %t", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIgnorePatternsWithSeparators(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir,
+		"a.tmp", "lib/b.tmp", "lib/b.go",
+		"node_modules/m.js", "web/node_modules/n.js",
+		"src/generated/g.go", "src/main.go",
+	)
+
+	w, _ := newTestWatcher(t, Options{
+		Watch:  []string{dir},
+		Ignore: []string{"**/*.tmp", "node_modules", "src/generated"},
+	})
+
+	want := []string{
+		"lib", "lib/b.go",
+		"src", "src/main.go",
+		"web", "web/node_modules", "web/node_modules/n.js",
+	}
+	if got := listed(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGlobWatchRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a[1].txt", "src/", "src/a.go", "src/b.go", "src/c.txt", "src/sub/", "src/sub/d.go")
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"src/*.go", []string{"src/a.go", "src/b.go"}},
+		{"src/**/*.go", []string{"src/a.go", "src/b.go", "src/sub/d.go"}},
+		{"**", []string{"a[1].txt", "src"}},
+		{"a[1].txt", []string{"a[1].txt"}},
+		{"src/*.rs", []string{"src/*.rs"}},
+	}
+
+	for _, tt := range tests {
+		w, _ := newTestWatcher(t, Options{
+			Root:         dir,
+			Watch:        []string{tt.pattern},
+			AllowMissing: true,
+		})
+
+		var got []string
+		for _, root := range w.opts.Watch {
+			rel, err := filepath.Rel(dir, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, filepath.ToSlash(rel))
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestUnmatchedGlobFails(t *testing.T) {
+	_, err := New(Options{
+		Root:  t.TempDir(),
+		Watch: []string{"src/*.go"},
+		Exec:  [][]string{{"true"}},
+	})
+
+	if err == nil {
+		t.Error("got no error for a pattern matching nothing")
+	}
+}
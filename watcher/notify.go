@@ -0,0 +1,166 @@
+package watcher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// notifyLatency is how long raw notifications are gathered for before being
+// signaled, so that a single operation, which often produces several of them,
+// is not observed halfway through.
+const notifyLatency = 50 * time.Millisecond
+
+// watchLimitError is returned by sync when the system has run out of watches
+// for the directories, which are then left to be polled. The hint tells how
+// the limit may be raised.
+type watchLimitError struct {
+	unwatched int
+	hint      string
+}
+
+func (err *watchLimitError) Error() string {
+	return fmt.Sprintf("ran out of watches for %d directories; %s", err.unwatched, err.hint)
+}
+
+// notifier signals that something may have changed under the paths it has
+// been told about, so a new snapshot is worth taking.
+type notifier interface {
+	events() <-chan struct{}
+
+	// sync starts watching the given roots and every directory in snap that
+	// is not being watched yet. If it runs out of watches, it still watches
+	// as many directories as it can and returns a *watchLimitError.
+	sync(roots []string, snap snapshot) error
+
+	close() error
+}
+
+// fsNotifier is the notifier of every platform fsnotify supports, which
+// watches over each directory on its own, as not every platform can watch
+// over whole trees.
+type fsNotifier struct {
+	fsw     *fsnotify.Watcher
+	watched map[string]bool
+	notify  chan struct{}
+}
+
+func newNotifier() (notifier, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &fsNotifier{
+		fsw:     fsw,
+		watched: make(map[string]bool),
+		notify:  make(chan struct{}, 1),
+	}
+
+	go n.read()
+	return n, nil
+}
+
+// read drains the raw events, coalescing them into a single pending
+// notification, since they only serve as a hint to take a new snapshot. Errors,
+// such as the events overflowing, are taken as hints as well, as whatever has
+// been missed is caught by the next snapshot.
+func (n *fsNotifier) read() {
+	var pending atomic.Bool
+	signal := func() {
+		pending.Store(false)
+
+		select {
+		case n.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	for {
+		select {
+		case _, ok := <-n.fsw.Events:
+			if !ok {
+				return
+			}
+		case _, ok := <-n.fsw.Errors:
+			if !ok {
+				return
+			}
+		}
+
+		if pending.CompareAndSwap(false, true) {
+			time.AfterFunc(notifyLatency, signal)
+		}
+	}
+}
+
+func (n *fsNotifier) events() <-chan struct{} {
+	return n.notify
+}
+
+func (n *fsNotifier) sync(roots []string, snap snapshot) error {
+	present := make(map[string]bool, len(n.watched))
+	unwatched := 0
+
+	add := func(path string) error {
+		present[path] = true
+		if n.watched[path] {
+			return nil
+		}
+
+		if err := n.fsw.Add(path); err != nil {
+			// the directory is left unwatched, to be added once there is room
+			if isWatchLimit(err) {
+				unwatched++
+				return nil
+			}
+
+			return err
+		}
+
+		n.watched[path] = true
+		return nil
+	}
+
+	for _, root := range roots {
+		// missing roots are left to the scans, as there is nothing to watch
+		if _, ok := snap.files[root]; !ok {
+			continue
+		}
+
+		if err := add(root); err != nil {
+			return err
+		}
+	}
+
+	for path, st := range snap.files {
+		if !st.isDir {
+			continue
+		}
+
+		if err := add(path); err != nil {
+			return err
+		}
+	}
+
+	// the watches of deleted directories are mostly dropped by the system, and
+	// otherwise removed here, so that they are added again if recreated
+	for path := range n.watched {
+		if !present[path] {
+			n.fsw.Remove(path)
+			delete(n.watched, path)
+		}
+	}
+
+	if unwatched != 0 {
+		return &watchLimitError{unwatched: unwatched, hint: watchLimitHint()}
+	}
+
+	return nil
+}
+
+func (n *fsNotifier) close() error {
+	return n.fsw.Close()
+}
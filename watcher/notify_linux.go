@@ -0,0 +1,33 @@
+//go:build linux
+
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// maxUserWatches holds the limit of inotify watches for each user.
+const maxUserWatches = "/proc/sys/fs/inotify/max_user_watches"
+
+// isWatchLimit reports whether err is from running out of inotify watches.
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// watchLimitHint tells the current limit of inotify watches and how to raise
+// it.
+func watchLimitHint() string {
+	const raise = "raise it with: sysctl fs.inotify.max_user_watches=<n>"
+
+	data, err := os.ReadFile(maxUserWatches)
+	if err != nil {
+		return "the limit of inotify watches has been reached, " + raise
+	}
+
+	limit := strings.TrimSpace(string(data))
+	return fmt.Sprintf("the limit of %s inotify watches has been reached, %s", limit, raise)
+}
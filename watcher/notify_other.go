@@ -0,0 +1,19 @@
+//go:build !linux
+
+package watcher
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isWatchLimit reports whether err is from running out of file descriptors,
+// which kqueue takes one of for every path it watches.
+func isWatchLimit(err error) bool {
+	return errors.Is(err, syscall.EMFILE)
+}
+
+// watchLimitHint tells how the limit of open files may be raised.
+func watchLimitHint() string {
+	return "the limit of open files has been reached, raise it with: ulimit -n <n>"
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package watcher
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/alan-b-lima/watcher/ansi-escape"
+)
+
+// setProcessGroup gives the command a process group of its own, which is in
+// the background of the terminal, where reading from it would stop the
+// command with SIGTTIN. A terminal is then not given to it as its input.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if file, ok := cmd.Stdin.(*os.File); ok && ansi.IsTerminal(file.Fd()) {
+		cmd.Stdin = nil
+	}
+}
+
+// terminate asks the process to exit, along with the rest of its group if it
+// leads one, by sending it sig, or SIGTERM if none is given.
+func terminate(cmd *exec.Cmd, sig os.Signal) error {
+	signal, ok := sig.(syscall.Signal)
+	if !ok {
+		signal = syscall.SIGTERM
+	}
+
+	if isGroupLeader(cmd) {
+		return syscall.Kill(-cmd.Process.Pid, signal)
+	}
+
+	return cmd.Process.Signal(signal)
+}
+
+// kill forces the process to exit, along with the rest of its group if it
+// leads one.
+func kill(cmd *exec.Cmd) error {
+	if isGroupLeader(cmd) {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	return cmd.Process.Kill()
+}
+
+func isGroupLeader(cmd *exec.Cmd) bool {
+	return cmd.SysProcAttr != nil && (cmd.SysProcAttr.Setpgid || cmd.SysProcAttr.Setsid)
+}
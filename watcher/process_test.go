@@ -0,0 +1,34 @@
+//go:build !windows
+
+package watcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+func TestProcessGroupNotGivenTerminal(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+
+	for _, opts := range []Options{{Restart: true}, {Timeout: time.Minute}} {
+		opts.Stdin = tty
+		opts.Exec = [][]string{{"read line; echo status=$?"}}
+
+		w, out := newTestWatcher(t, opts)
+		if err := runOnce(t, w); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(out.String(), "status=1") {
+			t.Errorf("restart %t, timeout %s: got %q, want the terminal not to be read", opts.Restart, opts.Timeout, out)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+//go:build windows
+
+package watcher
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminate asks the process to exit, along with the rest of its group if it
+// leads one, by sending it a CTRL_BREAK_EVENT, which is the only console event
+// that can be sent to a process group. Processes without one are killed. As
+// signals cannot be sent on Windows, sig is disregarded.
+func terminate(cmd *exec.Cmd, _ os.Signal) error {
+	if isGroupLeader(cmd) {
+		return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(cmd.Process.Pid))
+	}
+
+	return cmd.Process.Kill()
+}
+
+// kill forces the process to exit, along with its descendants if it leads a
+// group, as killing it alone would leave behind the processes started by it,
+// such as those started by cmd /c.
+func kill(cmd *exec.Cmd) error {
+	if isGroupLeader(cmd) {
+		taskkill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+		if err := taskkill.Run(); err == nil {
+			return nil
+		}
+	}
+
+	return cmd.Process.Kill()
+}
+
+func isGroupLeader(cmd *exec.Cmd) bool {
+	return cmd.SysProcAttr != nil && cmd.SysProcAttr.CreationFlags&windows.CREATE_NEW_PROCESS_GROUP != 0
+}
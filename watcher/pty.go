@@ -0,0 +1,107 @@
+//go:build !windows
+
+package watcher
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alan-b-lima/watcher/ansi-escape"
+	"github.com/creack/pty"
+)
+
+const ptySupported = true
+
+// defaultPTYSize is the size of the pseudo-terminals when Stdout is not a
+// terminal whose size they could take.
+var defaultPTYSize = pty.Winsize{Cols: 80, Rows: 24}
+
+// pseudoTerminal is the terminal a command is attached to in PTY mode, what the
+// command writes to it being copied to where its output would have gone.
+type pseudoTerminal struct {
+	ptmx, tty *os.File
+	copied    chan struct{}
+	resizes   chan os.Signal
+}
+
+// attachPTY attaches cmd to a new pseudo-terminal, as its standard output and,
+// unless it is left out, its standard error, which a terminal does not tell
+// apart. The standard input is left as it is. The command leads a session of
+// its own, of which the terminal is the controlling one, and so leads its own
+// process group as well.
+func (w *Watcher) attachPTY(cmd *exec.Cmd) (*pseudoTerminal, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	// newlines are translated, if need be, by the terminal the output ends up
+	// in, if any
+	if err := ansi.NoNewlineTranslation(tty.Fd()); err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, err
+	}
+
+	t := &pseudoTerminal{ptmx: ptmx, tty: tty, copied: make(chan struct{})}
+
+	// the terminal follows the size of the one the output goes to, if it is
+	// one, as it is resized
+	if out, ok := w.opts.Stdout.(*os.File); ok && ansi.IsTerminal(out.Fd()) {
+		pty.InheritSize(out, ptmx)
+
+		t.resizes = make(chan os.Signal, 1)
+		signal.Notify(t.resizes, syscall.SIGWINCH)
+		go func() {
+			for range t.resizes {
+				pty.InheritSize(out, ptmx)
+			}
+		}()
+	} else {
+		pty.Setsize(ptmx, &defaultPTYSize)
+	}
+
+	out := cmd.Stdout
+	go func() {
+		defer close(t.copied)
+		io.Copy(out, ptmx)
+	}()
+
+	cmd.Stdout = tty
+	if cmd.Stderr != nil {
+		cmd.Stderr = tty
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+
+	return t, nil
+}
+
+// started lets go of the side of the terminal given to the command, once it has
+// started, so that reading from the terminal ends once the command exits.
+func (t *pseudoTerminal) started() {
+	t.tty.Close()
+}
+
+// close waits for the output of the command to be copied, or for the grace
+// period, as the processes started by the command may hold onto the terminal,
+// and closes the terminal.
+func (t *pseudoTerminal) close() {
+	t.tty.Close()
+
+	if t.resizes != nil {
+		signal.Stop(t.resizes)
+		close(t.resizes)
+	}
+
+	select {
+	case <-t.copied:
+	case <-time.After(GracePeriod):
+	}
+
+	t.ptmx.Close()
+	<-t.copied
+}
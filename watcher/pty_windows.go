@@ -0,0 +1,23 @@
+//go:build windows
+
+package watcher
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+const ptySupported = false
+
+// pseudoTerminal is never made on Windows, see attachPTY.
+type pseudoTerminal struct{}
+
+// attachPTY fails, as commands are not attached to pseudo-terminals on
+// Windows, which New reports beforehand.
+func (w *Watcher) attachPTY(_ *exec.Cmd) (*pseudoTerminal, error) {
+	return nil, errUnsupportedOS(runtime.GOOS)
+}
+
+func (t *pseudoTerminal) started() {}
+
+func (t *pseudoTerminal) close() {}
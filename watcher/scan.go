@@ -0,0 +1,53 @@
+package watcher
+
+// Changes are the paths that have changed in between two scans, each sorted.
+// Directories are only ever added or removed, their own mod times being
+// disregarded, and are left out altogether if the Extensions or Include
+// options are given.
+type Changes struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+// Empty reports whether nothing has changed.
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Modified) == 0
+}
+
+// Scan walks the paths watched over once, as Run does on every tick, and
+// returns what has changed since the previous call to Scan or Prime, the files
+// as they are now being what the next call compares against. The first call
+// returns every path as added, unless Prime has been called before. It is for
+// polling on a schedule of one's own, rather than with Run, and is safe to be
+// called from several goroutines, but not while Run is running, as what is
+// known of the files is shared.
+func (w *Watcher) Scan() (Changes, error) {
+	w.scanMu.Lock()
+	defer w.scanMu.Unlock()
+
+	snap, err := w.takeSnapshot()
+	if err != nil {
+		return Changes{}, err
+	}
+
+	added, removed, modified := snap.diff(w.scanned)
+	w.scanned = snap
+
+	return Changes{Added: added, Removed: removed, Modified: modified}, nil
+}
+
+// Prime takes the files as they are now as what the next call to Scan compares
+// against, without reporting them.
+func (w *Watcher) Prime() error {
+	w.scanMu.Lock()
+	defer w.scanMu.Unlock()
+
+	snap, err := w.takeSnapshot()
+	if err != nil {
+		return err
+	}
+
+	w.scanned = snap
+	return nil
+}
@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt", "b.txt")
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}})
+
+	changes, err := w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{dir, path("a.txt"), path("b.txt")}; !slices.Equal(changes.Added, want) {
+		t.Errorf("first scan: got %q added, want %q", changes.Added, want)
+	}
+
+	changes, err = w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changes.Empty() {
+		t.Errorf("second scan: got %+v, want nothing", changes)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path("a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(path("b.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, dir, "c.txt")
+
+	changes, err = w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Changes{
+		Added:    []string{path("c.txt")},
+		Removed:  []string{path("b.txt")},
+		Modified: []string{path("a.txt")},
+	}
+	if !slices.Equal(changes.Added, want.Added) || !slices.Equal(changes.Removed, want.Removed) || !slices.Equal(changes.Modified, want.Modified) {
+		t.Errorf("got %+v, want %+v", changes, want)
+	}
+}
+
+func TestPrimeBeforeScan(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt")
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}})
+	if err := w.Prime(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changes.Empty() {
+		t.Errorf("got %+v after priming, want nothing", changes)
+	}
+}
@@ -0,0 +1,125 @@
+package watcher
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+)
+
+// stateVersion is the version of the format of the state files, to be bumped
+// whenever it changes in a way older watchers would misread.
+const stateVersion = 1
+
+// state is what is saved to the state file, the snapshot the commands have
+// last been run for, along with what it has been taken with.
+type state struct {
+	Version   int                   `json:"version"`
+	Watch     []string              `json:"watch"`
+	Hashed    bool                  `json:"hashed"`
+	FilesOnly bool                  `json:"files_only"`
+	Files     map[string]stateEntry `json:"files"`
+}
+
+type stateEntry struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	Dir     bool      `json:"dir,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// loadState reads the snapshot saved to the state file, if there is one that
+// has been taken the way snap has. Those that cannot be used are warned about
+// and disregarded, as if there were none.
+func (w *Watcher) loadState(snap snapshot) (snapshot, bool) {
+	data, err := os.ReadFile(w.opts.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return snapshot{}, false
+	}
+
+	if err != nil {
+		w.warn("ignoring the state file: " + err.Error())
+		return snapshot{}, false
+	}
+
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		w.warn(fmt.Sprintf("ignoring the state file %s: %s", w.opts.StateFile, err))
+		return snapshot{}, false
+	}
+
+	switch {
+	case saved.Version != stateVersion:
+		w.warn(fmt.Sprintf("ignoring the state file %s, whose version is %d rather than %d", w.opts.StateFile, saved.Version, stateVersion))
+		return snapshot{}, false
+
+	case !slices.Equal(saved.Watch, w.opts.Watch) || saved.Hashed != snap.hashed || saved.FilesOnly != snap.filesOnly:
+		w.warn(fmt.Sprintf("ignoring the state file %s, which has been saved for other paths or options", w.opts.StateFile))
+		return snapshot{}, false
+	}
+
+	prev := snapshot{
+		files:     make(map[string]fileState, len(saved.Files)),
+		filesOnly: saved.FilesOnly,
+		hashed:    saved.Hashed,
+	}
+
+	for path, entry := range saved.Files {
+		st := fileState{modTime: entry.ModTime, size: entry.Size, isDir: entry.Dir}
+		if entry.Hash != "" {
+			sum, err := hex.DecodeString(entry.Hash)
+			if err != nil || len(sum) != len(st.hash) {
+				w.warn(fmt.Sprintf("ignoring the state file %s, whose hash of %s is malformed", w.opts.StateFile, path))
+				return snapshot{}, false
+			}
+
+			copy(st.hash[:], sum)
+		}
+
+		prev.files[path] = st
+	}
+
+	return prev, true
+}
+
+// saveState writes snap to the state file, replacing it at once, so that it
+// is never left halfway written. Failing to is only warned about.
+func (w *Watcher) saveState(snap snapshot) {
+	saved := state{
+		Version:   stateVersion,
+		Watch:     w.opts.Watch,
+		Hashed:    snap.hashed,
+		FilesOnly: snap.filesOnly,
+		Files:     make(map[string]stateEntry, len(snap.files)),
+	}
+
+	for path, st := range snap.files {
+		entry := stateEntry{ModTime: st.modTime, Size: st.size, Dir: st.isDir}
+		if snap.hashed && !st.isDir {
+			entry.Hash = hex.EncodeToString(st.hash[:])
+		}
+
+		saved.Files[path] = entry
+	}
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		w.warn("the state could not be saved: " + err.Error())
+		return
+	}
+
+	tmp := w.opts.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		w.warn("the state could not be saved: " + err.Error())
+		return
+	}
+
+	if err := os.Rename(tmp, w.opts.StateFile); err != nil {
+		os.Remove(tmp)
+		w.warn("the state could not be saved: " + err.Error())
+	}
+}
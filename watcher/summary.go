@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/alan-b-lima/watcher/ansi-escape"
+)
+
+// stats counts what the watcher has done since Run has started, for the
+// Summary. The runs are recorded from other goroutines in restart mode.
+type stats struct {
+	since     time.Time
+	scans     atomic.Int64
+	runs      atomic.Int64
+	succeeded atomic.Int64
+	failed    atomic.Int64
+	busy      atomic.Int64
+}
+
+// record counts r, which has ended with err.
+func (s *stats) record(r *run, err error) {
+	s.runs.Add(1)
+	s.busy.Add(int64(r.duration))
+
+	switch {
+	case err == nil:
+		s.succeeded.Add(1)
+	case isFailure(err):
+		s.failed.Add(1)
+	}
+}
+
+// summarize prints how many times the commands have run, how many of those
+// they have succeeded and failed, how long they have run for in all, how many
+// scans have been made and for how long the watcher has been running.
+func (w *Watcher) summarize() {
+	if !w.opts.Summary || !w.logs(LevelInfo) {
+		return
+	}
+
+	runs, scans := w.stats.runs.Load(), w.stats.scans.Load()
+	line := fmt.Sprintf("ran %d %s, %d succeeded and %d failed, for %s in all; scanned %d %s in %s",
+		runs, plural(runs, "time", "times"), w.stats.succeeded.Load(), w.stats.failed.Load(),
+		roundDuration(time.Duration(w.stats.busy.Load())),
+		scans, plural(scans, "time", "times"), time.Since(w.stats.since).Round(time.Second))
+
+	fmt.Fprintf(w.opts.Stderr, "\n%s%s\n", w.prefix(), ansi.Gray(line))
+}
+
+func plural(n int64, one, many string) string {
+	if n == 1 {
+		return one
+	}
+
+	return many
+}
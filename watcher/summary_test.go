@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSummaryCounters(t *testing.T) {
+	dir := t.TempDir()
+	next := []string{"a.txt", "b.txt"}
+
+	w, out := newTestWatcher(t, Options{
+		Watch:     []string{dir},
+		TickSpeed: MinTickSpeed,
+		Count:     2,
+		Summary:   true,
+		Run: func(ctx context.Context, ev Event) error {
+			if len(next) == 0 {
+				return nil
+			}
+
+			name := next[0]
+			next = next[1:]
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+				return err
+			}
+
+			if ev.Kind != "start" {
+				return errors.New("failed on purpose")
+			}
+
+			return nil
+		},
+	})
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if runs := w.stats.runs.Load(); runs != 3 {
+		t.Errorf("got %d runs, want 3", runs)
+	}
+
+	if succeeded, failed := w.stats.succeeded.Load(), w.stats.failed.Load(); succeeded != 2 || failed != 1 {
+		t.Errorf("got %d succeeded and %d failed, want 2 and 1", succeeded, failed)
+	}
+
+	if scans := w.stats.scans.Load(); scans < 2 {
+		t.Errorf("got %d scans, want at least 2", scans)
+	}
+
+	if want := "ran 3 times, 2 succeeded and 1 failed"; !strings.Contains(out.String(), want) {
+		t.Errorf("%q is missing from %q", want, out)
+	}
+}
@@ -0,0 +1,145 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// readTail sends the data appended to the Tail file until ctx is done, closing
+// the channel once it can no longer be read, which is warned about.
+func (w *Watcher) readTail(ctx context.Context) <-chan []byte {
+	chunks := make(chan []byte)
+
+	go func() {
+		defer close(chunks)
+
+		info, err := os.Stat(w.opts.Tail)
+		if err == nil {
+			if info.Mode()&fs.ModeNamedPipe != 0 {
+				err = w.tailPipe(ctx, chunks)
+			} else {
+				err = w.tailFile(ctx, chunks)
+			}
+		}
+
+		if err != nil && ctx.Err() == nil {
+			w.warn("stopped reading " + w.opts.Tail + ": " + err.Error())
+		}
+	}()
+
+	return chunks
+}
+
+// tailPipe sends the data written to the named pipe as it is read. The pipe is
+// opened for writing as well, so that it is never left without a writer, in
+// which case reading it would end, and its writers may come and go.
+func (w *Watcher) tailPipe(ctx context.Context, chunks chan<- []byte) error {
+	file, err := os.OpenFile(w.opts.Tail, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// closing the pipe is what interrupts the read in progress
+	defer context.AfterFunc(ctx, func() { file.Close() })()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case chunks <- append([]byte(nil), buf[:n]...):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// tailFile sends the data appended to the file since it was last read, every
+// tick, beginning from its end. Once truncated, the file is read from its start
+// again, as is the file taking its place once it has been replaced, as when
+// rotated. While there is no file at the path, the one read so far is kept.
+func (w *Watcher) tailFile(ctx context.Context, chunks chan<- []byte) error {
+	file, err := os.Open(w.opts.Tail)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(w.opts.TickSpeed)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(w.opts.Tail)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		current, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !os.SameFile(info, current):
+			next, err := os.Open(w.opts.Tail)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			file.Close()
+			file, offset = next, 0
+
+		case info.Size() < offset:
+			offset = 0
+		}
+
+		if info.Size() <= offset {
+			continue
+		}
+
+		data := make([]byte, info.Size()-offset)
+		n, err := file.ReadAt(data, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		offset += int64(n)
+
+		select {
+		case chunks <- data[:n]:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,825 @@
+package watcher
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type snapshot struct {
+	files map[string]fileState
+
+	// filesOnly makes compare disregard directories coming and going, as when
+	// only the files of some extensions or patterns are of interest.
+	filesOnly bool
+
+	// hashed makes compare take files as modified only if their contents
+	// have changed, rather than their mod times.
+	hashed bool
+}
+
+// fileState is what is known of a path as of a scan. The size is kept along
+// with the mod time, as filesystems with a coarse resolution, such as FAT with
+// its two seconds, may give two writes in a row the same mod time.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+	hash    [sha256.Size]byte
+}
+
+type changeKind int
+
+const (
+	changeNone changeKind = iota
+	changeModified
+	changeAdded
+	changeRemoved
+	changeRenamed
+	changeTriggered
+	changeAppended
+)
+
+type change struct {
+	kind    changeKind
+	path    string
+	oldPath string
+
+	// files are all the paths that have changed, sorted, of which path is
+	// the most relevant.
+	files []string
+
+	// appended is the data appended to the Tail file, given to the commands
+	// on their standard input.
+	appended []byte
+}
+
+// merge combines ch with a change detected after it, which is taken as the
+// most relevant one, keeping the files of both.
+func (ch change) merge(next change) change {
+	files := append(slices.Clone(ch.files), next.files...)
+	slices.Sort(files)
+
+	next.files = slices.Compact(files)
+	next.appended = append(slices.Clone(ch.appended), next.appended...)
+	return next
+}
+
+// listing is the names of the entries in a directory as of when it had the
+// given mod time.
+type listing struct {
+	modTime time.Time
+	names   []string
+}
+
+// ignoreRule is a single line of an ignore file. Its pattern is matched
+// relative to each watch root, as described by matchPattern, unless it is
+// anchored by a leading slash, in which case it is matched as a whole path.
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+
+	// origin is where the rule has been read from, as the name of the file
+	// and the number of the line
+	origin string
+}
+
+// String returns the rule as written in the ignore file, along with where.
+func (rule ignoreRule) String() string {
+	var b strings.Builder
+	if rule.negate {
+		b.WriteByte('!')
+	}
+
+	if rule.anchored {
+		b.WriteByte('/')
+	}
+
+	b.WriteString(rule.pattern)
+	if rule.dirOnly {
+		b.WriteByte('/')
+	}
+
+	return b.String() + " in " + rule.origin
+}
+
+func (rule ignoreRule) match(name string) bool {
+	if rule.anchored {
+		return matchPath(rule.pattern, name)
+	}
+
+	return matchPattern(rule.pattern, name)
+}
+
+func (w *Watcher) readIgnoreFiles() error {
+	for _, name := range w.opts.IgnoreFiles {
+		rules, err := parseIgnoreFile(name)
+		if err != nil {
+			return err
+		}
+
+		w.ignoreRules = append(w.ignoreRules, rules...)
+	}
+
+	return nil
+}
+
+// gitignore is the name of the ignore files looked for in every directory in
+// Git mode.
+const gitignore = ".gitignore"
+
+func parseIgnoreFile(name string) ([]ignoreRule, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{origin: fmt.Sprintf("%s:%d", name, n)}
+		line, rule.negate = strings.CutPrefix(line, "!")
+		line, rule.dirOnly = strings.CutSuffix(line, "/")
+		rule.pattern, rule.anchored = strings.CutPrefix(line, "/")
+
+		if err := validatePattern(rule.pattern); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// walk is the state shared by the walks of the roots, which run concurrently.
+// The calls to the action are serialized, so that it needs no locking.
+type walk struct {
+	mu       sync.Mutex
+	visited  []fs.FileInfo
+	listings map[string]listing
+	nested   map[string][]ignoreRule
+	included func(root, path string, info fs.FileInfo) bool
+	action   func(string, fs.FileInfo) error
+}
+
+func (wk *walk) visit(root, path string, info fs.FileInfo) error {
+	if !wk.included(root, path, info) {
+		return nil
+	}
+
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	return wk.action(path, info)
+}
+
+// firstVisit reports whether the directory has not been visited before,
+// marking it as visited.
+func (wk *walk) firstVisit(info fs.FileInfo) bool {
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	if slices.ContainsFunc(wk.visited, func(seen fs.FileInfo) bool { return os.SameFile(seen, info) }) {
+		return false
+	}
+
+	wk.visited = append(wk.visited, info)
+	return true
+}
+
+// readGitignore reads the ignore file in dir, if there is one, whose rules
+// apply to the paths under dir.
+func (wk *walk) readGitignore(dir string) error {
+	rules, err := parseIgnoreFile(filepath.Join(dir, gitignore))
+	if errors.Is(err, fs.ErrNotExist) || len(rules) == 0 {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	wk.nested[dir] = rules
+	return nil
+}
+
+// nestedRules returns the rules of the ignore files read from the directories
+// between root and name, outermost first, each with the path of name relative
+// to the directory it has been read from.
+func (wk *walk) nestedRules(root, name string) []scopedRules {
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	if len(wk.nested) == 0 {
+		return nil
+	}
+
+	var scoped []scopedRules
+	for dir := filepath.Dir(name); isWithin(root, dir); dir = filepath.Dir(dir) {
+		if rules, ok := wk.nested[dir]; ok {
+			rel, err := filepath.Rel(dir, name)
+			if err == nil {
+				scoped = append(scoped, scopedRules{rel: filepath.ToSlash(rel), rules: rules})
+			}
+		}
+
+		if dir == root {
+			break
+		}
+	}
+
+	slices.Reverse(scoped)
+	return scoped
+}
+
+// scopedRules are the rules of an ignore file along with the path they are
+// matched against, relative to the directory of the file.
+type scopedRules struct {
+	rel   string
+	rules []ignoreRule
+}
+
+func (wk *walk) record(dir string, list listing) {
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	wk.listings[dir] = list
+}
+
+// selectiveWalk calls action for every file and directory being watched over,
+// except for those that are ignored. Roots that are files are stat'ed directly
+// and are never ignored, as they have been asked for explicitly. The roots are
+// walked concurrently, as many at a time as there are CPUs, and the errors of
+// all of them are reported.
+func (w *Watcher) selectiveWalk(action func(string, fs.FileInfo) error) error {
+	wk := &walk{
+		listings: make(map[string]listing),
+		nested:   make(map[string][]ignoreRule),
+		included: w.included,
+		action:   action,
+	}
+	fast := w.opts.FastScan && !w.opts.FollowSymlinks
+
+	errs := make([]error, len(w.opts.Watch))
+	sem := make(chan struct{}, runtime.NumCPU())
+
+	var wg sync.WaitGroup
+	for i, root := range w.opts.Watch {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = w.walkRoot(root, fast, wk)
+		}()
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if fast {
+		w.listings = wk.listings
+	}
+
+	return nil
+}
+
+func (w *Watcher) walkRoot(root string, fast bool, wk *walk) error {
+	info, err := os.Stat(root)
+	if w.opts.AllowMissing && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !info.IsDir():
+		return wk.visit(root, root, info)
+	case fast:
+		return w.scanDir(root, root, info, wk)
+	default:
+		return w.walkDir(root, root, root, wk)
+	}
+}
+
+// scanDir walks dir like walkDir does without following symbolic links, but
+// only lists dir if its mod time has changed since the previous scan, reusing
+// the previous listing otherwise. The listings made or reused are recorded,
+// to be used by the next scan.
+func (w *Watcher) scanDir(root, dir string, info fs.FileInfo, wk *walk) error {
+	if err := wk.visit(root, dir, info); err != nil {
+		return err
+	}
+
+	if w.opts.Git {
+		if err := wk.readGitignore(dir); err != nil && (dir == root || !w.skip(err)) {
+			return err
+		}
+	}
+
+	list, ok := w.listings[dir]
+	if !ok || !list.modTime.Equal(info.ModTime()) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if dir == root || !w.skip(err) {
+				return err
+			}
+
+			return nil
+		}
+
+		list = listing{modTime: info.ModTime(), names: make([]string, len(entries))}
+		for i, entry := range entries {
+			list.names[i] = entry.Name()
+		}
+	}
+
+	wk.record(dir, list)
+
+	for _, name := range list.names {
+		path := filepath.Join(dir, name)
+
+		// paths removed since they have been listed are skipped, which the
+		// mod time of their parent reflects, so it is listed again
+		info, err := os.Lstat(path)
+		if err != nil {
+			if !w.skip(err) {
+				return err
+			}
+
+			continue
+		}
+
+		if w.opts.IgnoreHidden && isHidden(path, info) {
+			w.debug("skipped %s, hidden", path)
+			continue
+		}
+
+		if rule, ok := w.ignoredBy(wk, root, path, info.IsDir()); ok {
+			w.ignored(path, rule)
+			continue
+		}
+
+		if info.IsDir() && !w.atMaxDepth(root, path) {
+			err = w.scanDir(root, path, info, wk)
+		} else {
+			err = wk.visit(root, path, info)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkDir walks dir, reporting the paths in it as if dir were at alias, which
+// differ when dir is the target of a symbolic link being followed. Symbolic
+// links are only followed if the options say so, in which case every
+// directory is walked at most once, so that links cannot lead into cycles.
+func (w *Watcher) walkDir(root, dir, alias string, wk *walk) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root || !w.skip(err) {
+				return err
+			}
+
+			return nil
+		}
+
+		if dir != alias {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			path = filepath.Join(alias, rel)
+		}
+
+		if rule, ok := w.ignoredBy(wk, root, path, d.IsDir()); ok {
+			w.ignored(path, rule)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if !w.skip(err) {
+				return err
+			}
+
+			return nil
+		}
+
+		if w.opts.IgnoreHidden && path != root && isHidden(path, info) {
+			w.debug("skipped %s, hidden", path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if w.opts.FollowSymlinks && info.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				// the link is dangling, so it is taken as it is
+				return wk.visit(root, path, info)
+			}
+
+			if !target.IsDir() {
+				return wk.visit(root, path, target)
+			}
+
+			real, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				if !w.skip(err) {
+					return err
+				}
+
+				return nil
+			}
+
+			return w.walkDir(root, real, path, wk)
+		}
+
+		if w.opts.FollowSymlinks && d.IsDir() && !wk.firstVisit(info) {
+			return filepath.SkipDir
+		}
+
+		if err := wk.visit(root, path, info); err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if w.atMaxDepth(root, path) {
+			return filepath.SkipDir
+		}
+
+		if w.opts.Git {
+			if err := wk.readGitignore(path); err != nil && !w.skip(err) {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// skip reports whether err, met below a root, is to be passed over rather than
+// stop the scan. Paths removed while being walked always are, as the next scan
+// accounts for them, while other errors are only in the SkipErrors mode, being
+// warned about once per path.
+func (w *Watcher) skip(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+
+	if !w.opts.SkipErrors {
+		return false
+	}
+
+	key := err.Error()
+	if perr, ok := err.(*fs.PathError); ok {
+		key = perr.Path
+	}
+
+	if _, warned := w.skipped.LoadOrStore(key, true); !warned {
+		w.warn(err.Error() + ", skipping it")
+	}
+
+	return true
+}
+
+// included reports whether the file at path, found under root, has one of the
+// extensions and is included by the include patterns, when they are given, as
+// matchPattern does, and is neither too large nor too old. Directories are
+// always included, as the files in them may be.
+func (w *Watcher) included(root, path string, info fs.FileInfo) bool {
+	if info.IsDir() {
+		return true
+	}
+
+	if w.opts.IgnoreLargerThan > 0 && info.Size() > w.opts.IgnoreLargerThan {
+		return false
+	}
+
+	if !w.cutoff.IsZero() && info.ModTime().Before(w.cutoff) {
+		return false
+	}
+
+	if len(w.opts.Extensions) > 0 && !slices.Contains(w.opts.Extensions, filepath.Ext(path)) {
+		return false
+	}
+
+	if len(w.opts.Include) == 0 {
+		return true
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		rel = filepath.Base(path)
+	}
+	rel = filepath.ToSlash(rel)
+
+	// the patterns are matched in order, the last one matching deciding, as
+	// in ignore files, while exclusions alone include the rest
+	included := !slices.ContainsFunc(w.opts.Include, func(pattern string) bool { return !strings.HasPrefix(pattern, "!") })
+	for _, pattern := range w.opts.Include {
+		pattern, negate := strings.CutPrefix(pattern, "!")
+		if matchPattern(pattern, rel) {
+			included = !negate
+		}
+	}
+
+	return included
+}
+
+// atMaxDepth reports whether path is as deep below root as the walk goes, so
+// that, if it is a directory, it is not walked into.
+func (w *Watcher) atMaxDepth(root, path string) bool {
+	if w.opts.MaxDepth <= 0 {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return false
+	}
+
+	return strings.Count(rel, string(filepath.Separator))+1 >= w.opts.MaxDepth
+}
+
+// ignoredBy reports whether name, found under root, matches any of the ignore
+// patterns, or else whether the last ignore rule matching it ignores it, along
+// with the pattern or the rule that ignores it, and where the rule is from. Ignore patterns are anchored to the root,
+// unless absolute, while ignore rules follow matchPattern. Negated rules
+// re-include what earlier rules ignored. The rules of the ignore files found
+// along the walk come after those of the ignore files given, the innermost
+// last, and are matched relative to the directories they have been found in.
+func (w *Watcher) ignoredBy(wk *walk, root, name string, isDir bool) (string, bool) {
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == "." {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, ig := range w.opts.Ignore {
+		if filepath.IsAbs(ig) {
+			if matchPath(filepath.ToSlash(ig), filepath.ToSlash(name)) {
+				return ig, true
+			}
+
+			continue
+		}
+
+		if matchPath(ig, rel) {
+			return ig, true
+		}
+	}
+
+	if w.opts.Git && isDir && filepath.Base(name) == ".git" {
+		return ".git", true
+	}
+
+	scoped := append([]scopedRules{{rel: rel, rules: w.ignoreRules}}, wk.nestedRules(root, name)...)
+
+	var ignored *ignoreRule
+	for _, sc := range scoped {
+		for i, rule := range sc.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+
+			if rule.match(sc.rel) {
+				ignored = &sc.rules[i]
+			}
+		}
+	}
+
+	if ignored == nil || ignored.negate {
+		return "", false
+	}
+
+	return ignored.String(), true
+}
+
+func (w *Watcher) takeSnapshot() (snapshot, error) {
+	snap := snapshot{
+		files:     make(map[string]fileState),
+		filesOnly: len(w.opts.Extensions) > 0 || len(w.opts.Include) > 0,
+		hashed:    w.opts.Hash,
+	}
+	start, count := time.Now(), 0
+	w.stats.scans.Add(1)
+
+	err := w.selectiveWalk(func(path string, info fs.FileInfo) error {
+		st := fileState{modTime: info.ModTime(), size: info.Size(), isDir: info.IsDir()}
+		if snap.hashed && info.Mode().IsRegular() {
+			sum, err := w.hashes.sum(path, info)
+			if err != nil {
+				if !w.skip(err) {
+					return err
+				}
+
+				return nil
+			}
+
+			st.hash = sum
+		}
+
+		snap.files[path] = st
+		count++
+		return nil
+	})
+	if err != nil {
+		return snap, err
+	}
+
+	w.debug("scanned %d files in %s", count, time.Since(start).Round(time.Microsecond))
+	if snap.hashed {
+		w.debug("hash cache: %d hits, %d misses", w.hashes.hits, w.hashes.misses)
+		w.hashes.prune(snap)
+	}
+
+	return snap, nil
+}
+
+// errChanged stops the walk of changed as soon as a difference is found.
+var errChanged = errors.New("the files have changed")
+
+// changed reports whether the files differ from those in prev, as compare
+// would tell, without taking a snapshot, so that the scans finding nothing,
+// which most do, are spared from building one. It stops at the first file
+// added or modified, while removals are only found once everything has been
+// walked. It does not read the contents of the files, so it cannot be used in
+// hashed mode.
+func (w *Watcher) changed(prev snapshot) (bool, error) {
+	start, count, matched := time.Now(), 0, 0
+	w.stats.scans.Add(1)
+
+	err := w.selectiveWalk(func(path string, info fs.FileInfo) error {
+		count++
+
+		old, ok := prev.files[path]
+		switch {
+		case !ok && info.IsDir() && prev.filesOnly:
+			return nil
+		case !ok || old.isDir != info.IsDir():
+			return errChanged
+		case !old.isDir && (!old.modTime.Equal(info.ModTime()) || old.size != info.Size()):
+			return errChanged
+		}
+
+		matched++
+		return nil
+	})
+	if errors.Is(err, errChanged) {
+		w.debug("found a change after scanning %d files in %s", count, time.Since(start).Round(time.Microsecond))
+		return true, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	w.debug("scanned %d files in %s", count, time.Since(start).Round(time.Microsecond))
+	return matched != len(prev.files), nil
+}
+
+// compare reports the most relevant difference between prev and snap. Paths
+// that appeared or disappeared take precedence over modifications, and a path
+// removed alongside an added one with the same mod time is taken as a rename.
+func (snap snapshot) compare(prev snapshot) (change, bool) {
+	added, removed, modified := snap.diff(prev)
+
+	files := slices.Concat(modified, added, removed)
+	slices.Sort(files)
+
+	for _, from := range removed {
+		for _, to := range added {
+			if prev.files[from] == snap.files[to] {
+				return change{kind: changeRenamed, path: to, oldPath: from, files: files}, true
+			}
+		}
+	}
+
+	switch {
+	case len(added) > 0:
+		return change{kind: changeAdded, path: added[0], files: files}, true
+	case len(removed) > 0:
+		return change{kind: changeRemoved, path: removed[0], files: files}, true
+	case len(modified) > 0:
+		latest := modified[0]
+		for _, path := range modified[1:] {
+			if snap.files[path].modTime.After(snap.files[latest].modTime) {
+				latest = path
+			}
+		}
+
+		return change{kind: changeModified, path: latest, files: files}, true
+	}
+
+	return change{}, false
+}
+
+// diff returns the paths that have been added, removed and modified between
+// prev and snap, each sorted. Directory mod times are disregarded, as entries
+// coming and going are already accounted for, and otherwise ignored files
+// would bump their parents. Files whose size has changed are taken as modified
+// even if their mod time has not.
+func (snap snapshot) diff(prev snapshot) (added, removed, modified []string) {
+	for path, st := range snap.files {
+		old, ok := prev.files[path]
+		if !ok {
+			if !st.isDir || !snap.filesOnly {
+				added = append(added, path)
+			}
+
+			continue
+		}
+
+		if st.isDir || st.modTime.Equal(old.modTime) && st.size == old.size && !snap.hashed {
+			continue
+		}
+
+		if snap.hashed && st.hash == old.hash {
+			continue
+		}
+
+		modified = append(modified, path)
+	}
+
+	for path, st := range prev.files {
+		if _, ok := snap.files[path]; !ok && (!st.isDir || !snap.filesOnly) {
+			removed = append(removed, path)
+		}
+	}
+
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(modified)
+
+	return added, removed, modified
+}
+
+func (ch change) String() string {
+	switch ch.kind {
+	case changeAdded:
+		return ch.path + " has been added"
+	case changeRemoved:
+		return ch.path + " has been removed"
+	case changeRenamed:
+		return ch.oldPath + " has been renamed to " + ch.path
+	case changeModified:
+		return ch.path + " has changed"
+	case changeTriggered:
+		if ch.path == "" {
+			return "Triggered"
+		}
+
+		return ch.path + " has been triggered"
+	case changeAppended:
+		return ch.path + " has been appended to"
+	default:
+		return "First execution"
+	}
+}
@@ -0,0 +1,455 @@
+package watcher
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listed returns the paths w walks over, relative to root and slash
+// separated, the root itself being left out.
+func listed(t *testing.T, w *Watcher, root string) []string {
+	t.Helper()
+
+	paths, err := w.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rels []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rel != "." {
+			rels = append(rels, filepath.ToSlash(rel))
+		}
+	}
+
+	slices.Sort(rels)
+	return rels
+}
+
+func TestIgnoredFileKeepsSiblings(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt", "b.log", "c.txt", "sub/d.log", "sub/e.txt")
+
+	tests := []struct {
+		ignore string
+		want   []string
+	}{
+		{"b.log", []string{"a.txt", "c.txt", "sub", "sub/d.log", "sub/e.txt"}},
+		{"**/*.log", []string{"a.txt", "c.txt", "sub", "sub/e.txt"}},
+	}
+
+	for _, tt := range tests {
+		w, _ := newTestWatcher(t, Options{Watch: []string{dir}, Ignore: []string{tt.ignore}})
+
+		if got := listed(t, w, dir); !slices.Equal(got, tt.want) {
+			t.Errorf("-i %s: got %q, want %q", tt.ignore, got, tt.want)
+		}
+	}
+}
+
+func TestFileAndDirectoryRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "src/a.go", "src/lib/b.go", "config.json", "other.json", "docs/c.md")
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{
+		filepath.Join(dir, "src"),
+		filepath.Join(dir, "config.json"),
+	}})
+
+	// the siblings of the file are left out, as is the rest of its parent
+	want := []string{"config.json", "src", "src/a.go", "src/lib", "src/lib/b.go"}
+	if got := listed(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestIgnoreAnchoredToEachRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a/build/x.o", "a/rebuild/y.o", "b/build/z.o", "b/sub/build/w.o")
+
+	w, _ := newTestWatcher(t, Options{
+		Watch:  []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")},
+		Ignore: []string{"build"},
+	})
+
+	want := []string{"a", "a/rebuild", "a/rebuild/y.o", "b", "b/sub", "b/sub/build", "b/sub/build/w.o"}
+	if got := listed(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestOverlappingRootsVisitedOnce(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "src/a.go", "src/lib/b.go", "docs/c.md")
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{
+		dir,
+		filepath.Join(dir, "src"),
+		filepath.Join(dir, "src", "lib"),
+		filepath.Join(dir, "docs"),
+	}})
+
+	if want := []string{dir}; !slices.Equal(w.opts.Watch, want) {
+		t.Errorf("got the roots %q, want %q", w.opts.Watch, want)
+	}
+
+	visits := make(map[string]int)
+	err := w.selectiveWalk(func(path string, _ fs.FileInfo) error {
+		visits[path]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, n := range visits {
+		if n != 1 {
+			t.Errorf("%s has been visited %d times", path, n)
+		}
+	}
+
+	if len(visits) != 7 {
+		t.Errorf("got %d paths, want 7", len(visits))
+	}
+}
+
+func TestExtensionsFilterChanges(t *testing.T) {
+	dir := t.TempDir()
+
+	var paths []string
+	w, out := newTestWatcher(t, Options{
+		Watch:      []string{dir},
+		TickSpeed:  MinTickSpeed,
+		Count:      1,
+		Extensions: []string{"go"},
+		Exec:       [][]string{{"echo ran"}},
+		OnChange: func(ev Event) {
+			if ev.Kind != "start" {
+				paths = append(paths, filepath.Base(ev.Path))
+			}
+		},
+	})
+
+	result := runAsync(t, w)
+	waitFor(t, out, "ran")
+
+	writeTree(t, dir, "notes.md")
+	time.Sleep(20 * MinTickSpeed)
+	writeTree(t, dir, "main.go")
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change to main.go has not been noticed")
+	}
+
+	if !slices.Equal(paths, []string{"main.go"}) {
+		t.Errorf("got changes to %q, want only to main.go", paths)
+	}
+}
+
+func TestWalkErrorsOfEveryRoot(t *testing.T) {
+	a, b, c := t.TempDir(), t.TempDir(), t.TempDir()
+	w, _ := newTestWatcher(t, Options{Watch: []string{a, b, c}})
+
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(c); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := w.Scan()
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	for _, root := range []string{b, c} {
+		if !strings.Contains(err.Error(), root) {
+			t.Errorf("the error of %s is missing from %q", root, err)
+		}
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	var roots []string
+	for range 8 {
+		root := b.TempDir()
+		writeSyntheticTree(b, root, 10, 100, 0)
+		roots = append(roots, root)
+	}
+
+	action := func(string, fs.FileInfo) error { return nil }
+
+	b.Run("serial", func(b *testing.B) {
+		var ws []*Watcher
+		for _, root := range roots {
+			w, _ := newTestWatcher(b, Options{Watch: []string{root}})
+			ws = append(ws, w)
+		}
+
+		for b.Loop() {
+			for _, w := range ws {
+				if err := w.selectiveWalk(action); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		w, _ := newTestWatcher(b, Options{Watch: roots})
+
+		for b.Loop() {
+			if err := w.selectiveWalk(action); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt", "x/b.txt", "x/y/c.txt")
+
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{1, []string{"a.txt", "x"}},
+		{2, []string{"a.txt", "x", "x/b.txt", "x/y"}},
+		{0, []string{"a.txt", "x", "x/b.txt", "x/y", "x/y/c.txt"}},
+	}
+
+	for _, tt := range tests {
+		w, _ := newTestWatcher(t, Options{Watch: []string{dir}, MaxDepth: tt.depth})
+		if got := listed(t, w, dir); !slices.Equal(got, tt.want) {
+			t.Errorf("depth %d: got %q, want %q", tt.depth, got, tt.want)
+		}
+	}
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}, MaxDepth: 2})
+	if err := w.Prime(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, dir, "x/y/c.txt", "x/y/d.txt")
+
+	changes, err := w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changes.Empty() {
+		t.Errorf("got %+v for changes past the depth", changes)
+	}
+}
+
+func TestIgnoreHidden(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, ".git/HEAD", ".env", "config.go", "src/.cache/x", "src/main.go")
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}, IgnoreHidden: true})
+
+	want := []string{"config.go", "src", "src/main.go"}
+	if got := listed(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	hidden := filepath.Join(dir, ".git")
+	w, _ = newTestWatcher(t, Options{Watch: []string{hidden}, IgnoreHidden: true})
+
+	if got := listed(t, w, hidden); !slices.Equal(got, []string{"HEAD"}) {
+		t.Errorf("got %q under a hidden root, want %q", got, []string{"HEAD"})
+	}
+}
+
+func TestSameModTimeOtherSize(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt")
+	path := filepath.Join(dir, "a.txt")
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}})
+
+	prev, err := w.takeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("a longer a.txt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := w.changed(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changed {
+		t.Error("the change in size has not been noticed")
+	}
+
+	snap, err := w.takeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, ok := snap.compare(prev)
+	if !ok || ch.kind != changeModified || ch.path != path {
+		t.Errorf("got %+v, want %s modified", ch, path)
+	}
+}
+
+func TestIgnoreWholeSegments(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "test/a.go", "latest/b.go", "mytest/c.go")
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}, Ignore: []string{"test"}})
+
+	want := []string{"latest", "latest/b.go", "mytest", "mytest/c.go"}
+	if got := listed(t, w, dir); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExtractedTreeWithOldModTimes(t *testing.T) {
+	for _, exts := range [][]string{nil, {"go"}} {
+		dir := t.TempDir()
+		writeTree(t, dir, "main.go")
+
+		w, _ := newTestWatcher(t, Options{Watch: []string{dir}, Extensions: exts})
+
+		prev, err := w.takeSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeTree(t, dir, "pkg/a.go", "pkg/sub/b.go")
+
+		old := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+		for _, name := range []string{"pkg/sub/b.go", "pkg/a.go", "pkg/sub", "pkg"} {
+			if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		changed, err := w.changed(prev)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !changed {
+			t.Errorf("extensions %q: the extracted files have not been noticed", exts)
+		}
+
+		snap, err := w.takeSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ch, ok := snap.compare(prev); !ok || ch.kind != changeAdded {
+			t.Errorf("extensions %q: got %+v, want an addition", exts, ch)
+		}
+	}
+}
+
+func TestSizeAndAgeFilters(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "small.txt", "old.txt")
+
+	if err := os.WriteFile(filepath.Join(dir, "large.bin"), make([]byte, 4<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		size int64
+		age  time.Duration
+		want []string
+	}{
+		{0, 0, []string{"large.bin", "old.txt", "small.txt"}},
+		{1 << 10, 0, []string{"old.txt", "small.txt"}},
+		{0, 24 * time.Hour, []string{"large.bin", "small.txt"}},
+		{1 << 10, 24 * time.Hour, []string{"small.txt"}},
+	}
+
+	for _, tt := range tests {
+		w, _ := newTestWatcher(t, Options{Watch: []string{dir}, IgnoreLargerThan: tt.size, IgnoreOlderThan: tt.age})
+		if got := listed(t, w, dir); !slices.Equal(got, tt.want) {
+			t.Errorf("larger than %d, older than %s: got %q, want %q", tt.size, tt.age, got, tt.want)
+		}
+	}
+
+	w, _ := newTestWatcher(t, Options{Watch: []string{dir}, IgnoreLargerThan: 1 << 10})
+	if err := w.Prime(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "large.bin"), make([]byte, 8<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := w.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changes.Empty() {
+		t.Errorf("got %+v for a change to a file too large", changes)
+	}
+
+	if _, err := New(Options{Watch: []string{dir}, IgnoreLargerThan: -1, Exec: [][]string{{"true"}}}); err == nil {
+		t.Error("got no error for a negative size")
+	}
+}
+
+func TestIncludeExclusionOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "main.go", "main_test.go", "pkg/util.go", "pkg/util_test.go", "README.md")
+
+	tests := []struct {
+		include []string
+		want    []string
+	}{
+		{[]string{"*.go"}, []string{"main.go", "main_test.go", "pkg/util.go", "pkg/util_test.go"}},
+		{[]string{"*.go", "!*_test.go"}, []string{"main.go", "pkg/util.go"}},
+		{[]string{"!*_test.go", "*.go"}, []string{"main.go", "main_test.go", "pkg/util.go", "pkg/util_test.go"}},
+		{[]string{"*.go", "!*_test.go", "pkg/*_test.go"}, []string{"main.go", "pkg/util.go", "pkg/util_test.go"}},
+		{[]string{"!*.md"}, []string{"main.go", "main_test.go", "pkg/util.go", "pkg/util_test.go"}},
+	}
+
+	for _, tt := range tests {
+		w, _ := newTestWatcher(t, Options{Watch: []string{dir}, Include: tt.include})
+		if got := listed(t, w, dir); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.include, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,1209 @@
+// Package watcher watches over files and directories and runs commands
+// whenever changes are detected in them.
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	Placeholder  = "{}"
+	Granularity  = 100 * time.Millisecond
+	MinTickSpeed = 10 * time.Millisecond
+	GracePeriod  = 3 * time.Second
+
+	RetryBackoff    = 500 * time.Millisecond
+	MaxRetryBackoff = 30 * time.Second
+)
+
+var (
+	ErrNothingToWatchOver = errors.New("no file to watch over has been given")
+	ErrNoCommand          = errors.New("no command to be executed has been given")
+	ErrUnsupportedOS      = errors.New("unsupported OS")
+	errProcessStopped     = errors.New("the process has been stopped")
+	errTickSpeedTooSmall  = fmt.Errorf("the tick speed must be at least %s", MinTickSpeed)
+	errShellAndNoShell    = errors.New("a shell cannot be given if commands are not run through one")
+	errNegativeRetries    = errors.New("the number of retries cannot be negative")
+	errNegativeCount      = errors.New("the number of executions cannot be negative")
+	errNegativeFilter     = errors.New("the size and the age files are left out past cannot be negative")
+	errStdinTwice         = errors.New("stdin cannot be read both for triggers and for keys")
+	errDebounceAndBatch   = errors.New("changes cannot be both debounced and batched over a window")
+	errThrottleAndDelay   = errors.New("changes cannot be throttled while being debounced or batched")
+	errIgnoreSelfRestart  = errors.New("the changes made by the commands cannot be ignored when restarting them")
+	errMergeQuietStderr   = errors.New("the standard error of the commands cannot be both merged and left out")
+	errDirNotDir          = func(dir string) error { return fmt.Errorf("%s is not a directory", dir) }
+	errUnsupportedOS      = func(os string) error { return &unsupportedOSError{fmt.Errorf("%w: %s", ErrUnsupportedOS, os)} }
+	errTimedOut           = func(d time.Duration) error { return &timeoutError{fmt.Errorf("timed out after %s", d)} }
+	errMissingPaths       = func(paths []string) error {
+		return fmt.Errorf("the paths to watch over do not exist: %s", strings.Join(paths, ", "))
+	}
+	errUnknownBannerToken = func(token string) error {
+		return fmt.Errorf("unknown token %s in the banner format, expected one of %s", token, strings.Join(bannerTokens, ", "))
+	}
+)
+
+// Options configures a Watcher. Its fields mirror the flags of the command
+// line tool, and the zero value of each of them is its default.
+type Options struct {
+	// Name, if given, tells the Watcher apart from others sharing the same
+	// output, beginning the lines it reports its runs with and being set in
+	// its JSON events.
+	Name string
+
+	Watch       []string
+	Ignore      []string
+	IgnoreFiles []string
+	TickSpeed   time.Duration
+	Debounce    time.Duration
+	Exec        [][]string
+	Notify      bool
+
+	// Timeout, if given, terminates the commands still running after this
+	// long, taking it as a failure, while Restart terminates those still
+	// running when a change comes in, to run them again for it. Either way,
+	// the commands are given a process group of their own, so that what they
+	// start is terminated along with them. On Unix, such a group is in the
+	// background of the terminal, so a terminal given as Stdin is not given
+	// to the commands, which would be stopped on reading from it.
+	Timeout time.Duration
+	Restart bool
+
+	// BatchWindow, if given, runs the commands once for all the changes made
+	// over this long after the first one, rather than for each of them. The
+	// paths that have changed are given to the commands, one per line, in the
+	// WATCHER_CHANGED_FILES environment variable, which is also set otherwise.
+	BatchWindow time.Duration
+
+	// Throttle runs the commands at most once every this long. Unlike
+	// Debounce, which waits for the changes to settle, the first change runs
+	// them right away, while those coming in before the interval has elapsed
+	// since are held back and run together once it has.
+	Throttle time.Duration
+
+	// StartDelay is how long Run waits for before the first execution, as for
+	// the environment to be ready, returning at once if its context is done.
+	StartDelay time.Duration
+
+	// Extensions, if any are given, limits the files whose changes are
+	// detected to those with one of them, with or without the leading dot.
+	Extensions []string
+
+	// Include, if any patterns are given, limits the files whose changes are
+	// detected to those matching one of them. Patterns without slashes are
+	// matched against the base names, others against the paths relative to
+	// each root, as in ignore files. Patterns beginning with ! exclude the
+	// files they match instead, the last pattern matching a file deciding,
+	// so that "*.go" followed by "!*_test.go" leaves out the tests. If every
+	// pattern is an exclusion, the files matching none are included.
+	Include []string
+
+	// Retry is the number of times the commands are run again after failing,
+	// waiting for RetryBackoff at first and twice as long every time after,
+	// unless another change comes in first.
+	Retry int
+
+	// FailFast makes Run return as soon as the commands fail, once they have
+	// been retried as many times as allowed, with the error they have failed
+	// with, such as an *exec.ExitError, rather than waiting for the next
+	// change.
+	FailFast bool
+
+	// Hash detects modifications by the contents of the files rather than by
+	// their mod times, only reading the files whose size or mod time have
+	// changed since they were last read.
+	Hash bool
+
+	// Git reads the .gitignore files found in the directories walked, whose
+	// rules apply to the paths under the directories they are in, as they do
+	// in Git, and skips the .git directories.
+	Git bool
+
+	// SkipErrors passes over the paths that cannot be read below the roots,
+	// warning about each of them once, rather than stopping Run. The roots
+	// themselves must still be readable. Paths removed while being walked are
+	// always passed over.
+	SkipErrors bool
+
+	// IgnoreHidden skips the files and directories whose names begin with a
+	// dot, or that have the hidden attribute on Windows, apart from the paths
+	// to watch over themselves.
+	IgnoreHidden bool
+
+	// MaxDepth limits how many levels below each root are walked, the entries
+	// directly in it being at depth 1, so that 1 watches over only those.
+	// Zero means no limit.
+	MaxDepth int
+
+	// FastScan only lists the directories whose mod time has changed since
+	// the last scan, reusing the previous listing of the others, whose
+	// entries are still stat'ed. It relies on a directory's mod time changing
+	// whenever entries are added to, removed from or renamed within it, which
+	// holds on Linux, the BSDs, macOS and on NTFS, but not on FAT nor on some
+	// network filesystems. It has no effect when following symbolic links.
+	FastScan bool
+
+	// IgnoreLargerThan, if given, leaves out the files larger than this many
+	// bytes, such as the large artifacts of a build, for as long as they are.
+	// A file growing past it is then detected as removed.
+	IgnoreLargerThan int64
+
+	// IgnoreOlderThan, if given, leaves out the files last modified longer
+	// than this before the Watcher has been created. Files are not aged out
+	// while watching over them, so that they are not detected as removed.
+	IgnoreOlderThan time.Duration
+
+	// AllowMissing lets the paths to watch over not exist, in which case they
+	// are detected as added once they do.
+	AllowMissing bool
+
+	// FollowSymlinks walks the directories symbolic links lead to and reads
+	// the mod times of the files they lead to, rather than of the links.
+	FollowSymlinks bool
+
+	// NoPollFallback makes Run fail when the notifications cannot be set up,
+	// rather than falling back to polling. Otherwise, running out of watches
+	// leaves the directories that could not be watched to be polled, while
+	// the others are still notified about.
+	NoPollFallback bool
+
+	NoClear bool
+
+	// KeepOnFailure leaves the screen as it is when the previous commands
+	// have failed, rather than clearing it, so that their output remains
+	// above the banner of the next run.
+	KeepOnFailure bool
+
+	JSON        bool
+	JSONCapture bool
+
+	RequireChange bool
+	NoInitial     bool
+
+	// IgnoreSelf takes the files as they are once the commands are done as
+	// the ones to detect changes against, so that the files they write, such
+	// as build artifacts in the directories watched over, do not run them
+	// again. Changes made by others while the commands run are passed over as
+	// well, and files both read and written by the commands are only detected
+	// again once changed after the run. It cannot be set along with Restart.
+	IgnoreSelf bool
+
+	// Once makes Run return after the commands have run for the first change
+	// detected, with the error they have failed with, if any.
+	Once bool
+
+	// Count, if given, makes Run return after the commands have run for this
+	// many changes, with the error they have failed with the last time, if
+	// any, so that Once is the same as a Count of 1. If CountInitial is set,
+	// the first execution is counted as well.
+	Count        int
+	CountInitial bool
+
+	// StateFile, if given, is where the files as they were when the commands
+	// last ran are saved once Run returns, to be detected changes against
+	// the next time, so that what has changed in between runs the commands
+	// right away, and nothing having changed skips the first execution. A
+	// state file saved for other paths is disregarded with a warning.
+	StateFile string
+
+	// Summary reports, once Run returns, how many times the commands have run,
+	// succeeded and failed, how long they have run for in all, how many scans
+	// have been made and how long Run has run for. It is left out along with
+	// the banners, and in JSON mode.
+	Summary bool
+
+	// DryRun goes through the detection as usual, but prints the commands
+	// that would be run instead of running them, along with the paths ignored
+	// and the rules that ignore them, once each.
+	DryRun bool
+
+	// LogLevel is how much of its own output the watcher reports, LevelInfo
+	// by default.
+	LogLevel LogLevel
+
+	// Preview shows the paths that have changed while Debounce, BatchWindow or
+	// Throttle holds the commands back, along with how long is left before they run,
+	// on a line updated in place. It is left out along with the heartbeat.
+	Preview bool
+
+	// Verbose is the same as LogLevel being LevelDebug.
+	Verbose bool
+
+	// Quiet leaves out the banners, the heartbeat and the exit codes, so that
+	// only the output of the commands, the warnings and the errors remain, as
+	// does LogLevel being LevelWarn.
+	Quiet bool
+
+	// TriggerStdin runs the commands for every line read from Stdin as well,
+	// the line being taken as the path that has changed. The commands are
+	// then given no input, so that they do not take the lines for themselves.
+	TriggerStdin bool
+
+	// Interactive reads keys from Stdin, which should then be a terminal in
+	// raw mode, space pausing and resuming the watching, and r running the
+	// commands right away. The commands are then given no input either.
+	Interactive bool
+
+	// Tail, if given, is a file, such as a log or a named pipe, whose data
+	// runs the commands as it is appended, which they are given on their
+	// standard input, rather than as its mod time changes. The Watch paths
+	// may then be left empty. Files are read from their end as of when Run
+	// starts, every tick, and from their start again once truncated or
+	// replaced, as when rotated. Named pipes are read from as they are
+	// written to, and left waiting while nothing has them open for writing.
+	Tail string
+
+	// BannerFormat, if given, replaces the line printed before every run,
+	// where {time} stands for the time of the run, {file} for the path that
+	// has changed, {count} for the number of changes so far, {event} for the
+	// kind of change, as in the JSON events, {change} for its description as
+	// in the default banner, {task} for the Name and {retry} for the number
+	// of the retry, 0 at first. Other tokens in braces are rejected.
+	BannerFormat string
+
+	// NoHeartbeat leaves out the line with the time printed while idle, which
+	// otherwise refreshes on every tick, or at most every HeartbeatInterval.
+	NoHeartbeat       bool
+	HeartbeatInterval time.Duration
+
+	// OnSuccess and OnFail are run, through the shell unless NoShell is set,
+	// after the commands succeed or fail, respectively, given the exit code in
+	// the WATCHER_EXIT_CODE environment variable, which is -1 if they have
+	// timed out. Their output goes to Stderr, and their failures are warned
+	// about, but do not stop Run.
+	OnSuccess string
+	OnFail    string
+
+	// Dir is the directory the commands are run in, Root or the current one
+	// if empty.
+	Dir string
+
+	// CwdChanged runs the commands in the directory of the path they are run
+	// for, rather than in Dir, which is still used for the first execution
+	// and once that directory no longer exists, as when it has been removed.
+	CwdChanged bool
+
+	// Root, if given, is the directory the relative paths to watch over, to
+	// read ignore files from and to tail, Dir and StateFile are taken from,
+	// rather than the current one, so that the same options may be used from
+	// any directory.
+	Root string
+
+	// Env holds environment variables in the KEY=VALUE form, given to the
+	// commands on top of those of the process and those of the watcher, which
+	// they take precedence over.
+	Env []string
+
+	// Shell is the shell the commands are run through, instead of sh, or cmd
+	// on Windows. If NoShell is set, the commands are run directly instead.
+	Shell   string
+	NoShell bool
+
+	// Run, if given, is run in process after the commands, if any, as one
+	// more of them, debounced, throttled, retried and timed out the same way.
+	// Its context is canceled once a change supersedes it in restart mode,
+	// once it times out, or once the context of Run is done, after which it
+	// should return soon, as it is waited for. Its errors are taken as the
+	// commands failing, and are returned by Run with the Once option.
+	Run func(ctx context.Context, ev Event) error
+
+	// OnChange, if given, is called with every change the commands are about
+	// to run for, the first execution included, from the goroutine of Run,
+	// which waits for it to return. Without commands, Run only calls it.
+	OnChange func(ev Event)
+
+	// OnExec, if given, is called after every command has run, with the
+	// command as given in Exec and its exit code, -1 if it has not exited on
+	// its own. It is called from another goroutine in restart mode, and not
+	// at all in dry-run mode.
+	OnExec func(cmd []string, exitCode int)
+
+	// Stdin, Stdout and Stderr are given to the commands. The output of the
+	// watcher itself goes to Stderr, colored as ansi.SetColor says, except for
+	// the JSON events, which go to Stdout. They default to the standard
+	// streams of the process.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// MergeStderr gives the standard error of the commands to where their
+	// standard output goes, Stdout or the captured stdout in JSONCapture
+	// mode, while QuietStderr leaves it out. They cannot be set together.
+	MergeStderr bool
+	QuietStderr bool
+
+	// PTY attaches the commands to pseudo-terminals, as their standard output
+	// and standard error, so that they write as they would to a terminal,
+	// colors included. What they write is copied to Stdout, or to the captured
+	// stdout in JSONCapture mode, their standard error going along with it,
+	// unless QuietStderr is set. Their standard input is left as it is. It is
+	// not supported on Windows.
+	PTY bool
+}
+
+// Watcher runs commands whenever the files it watches over change.
+type Watcher struct {
+	opts        Options
+	ignoreRules []ignoreRule
+
+	running      *process
+	latest       *run
+	hashes       hashCache
+	listings     map[string]listing
+	skipped      sync.Map
+	reported     sync.Map
+	failed       chan *run
+	changes      int
+	ranInitially bool
+	beatenAt     time.Time
+	previewing   bool
+	failing      atomic.Bool
+	stats        stats
+	cutoff       time.Time
+	scanMu       sync.Mutex
+	scanned      snapshot
+	fatal        chan error
+	notifier     notifier
+	polled       bool
+	encoder      *eventEncoder
+}
+
+// New validates the options and creates a Watcher from them. The commands, the
+// Run option or the OnChange hook are only required by Run, so that a Watcher without them
+// may still List.
+func New(opts Options) (*Watcher, error) {
+	if len(opts.Watch) == 0 && opts.Tail == "" {
+		return nil, ErrNothingToWatchOver
+	}
+
+	opts.Watch = slices.Clone(opts.Watch)
+	opts.Ignore = slices.Clone(opts.Ignore)
+	opts.Include = slices.Clone(opts.Include)
+	opts.Env = slices.Clone(opts.Env)
+
+	opts.Extensions = slices.Clone(opts.Extensions)
+	for i, ext := range opts.Extensions {
+		opts.Extensions[i] = "." + strings.TrimPrefix(ext, ".")
+	}
+
+	if opts.TickSpeed == 0 {
+		opts.TickSpeed = Granularity
+	}
+
+	if opts.TickSpeed < MinTickSpeed {
+		return nil, errTickSpeedTooSmall
+	}
+
+	if opts.Shell != "" {
+		if opts.NoShell {
+			return nil, errShellAndNoShell
+		}
+
+		if _, err := exec.LookPath(opts.Shell); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Root != "" {
+		root, err := filepath.Abs(opts.Root)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			return nil, errDirNotDir(opts.Root)
+		}
+
+		opts.Root = root
+		opts.Dir = resolve(opts.Root, opts.Dir)
+		if opts.StateFile != "" {
+			opts.StateFile = resolve(opts.Root, opts.StateFile)
+		}
+
+		if opts.Tail != "" {
+			opts.Tail = resolve(opts.Root, opts.Tail)
+		}
+
+		ignoreFiles := make([]string, len(opts.IgnoreFiles))
+		for i, name := range opts.IgnoreFiles {
+			ignoreFiles[i] = resolve(opts.Root, name)
+		}
+		opts.IgnoreFiles = ignoreFiles
+	}
+
+	if opts.Dir != "" {
+		info, err := os.Stat(opts.Dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			return nil, errDirNotDir(opts.Dir)
+		}
+	}
+
+	if opts.Tail != "" {
+		tail, err := filepath.Abs(opts.Tail)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(tail); err != nil {
+			return nil, err
+		}
+
+		opts.Tail = tail
+	}
+
+	if opts.TriggerStdin && opts.Interactive {
+		return nil, errStdinTwice
+	}
+
+	if opts.Retry < 0 {
+		return nil, errNegativeRetries
+	}
+
+	if opts.Count < 0 {
+		return nil, errNegativeCount
+	}
+
+	if opts.IgnoreLargerThan < 0 || opts.IgnoreOlderThan < 0 {
+		return nil, errNegativeFilter
+	}
+
+	if opts.Debounce != 0 && opts.BatchWindow != 0 {
+		return nil, errDebounceAndBatch
+	}
+
+	if opts.Throttle != 0 && (opts.Debounce != 0 || opts.BatchWindow != 0) {
+		return nil, errThrottleAndDelay
+	}
+
+	if opts.IgnoreSelf && opts.Restart {
+		return nil, errIgnoreSelfRestart
+	}
+
+	if opts.MergeStderr && opts.QuietStderr {
+		return nil, errMergeQuietStderr
+	}
+
+	if opts.PTY && !ptySupported {
+		return nil, errUnsupportedOS(runtime.GOOS)
+	}
+
+	if err := validateBannerFormat(opts.BannerFormat); err != nil {
+		return nil, err
+	}
+
+	if opts.Verbose {
+		opts.LogLevel = LevelDebug
+	}
+
+	if opts.Quiet {
+		opts.LogLevel = max(opts.LogLevel, LevelWarn)
+	}
+
+	if opts.JSONCapture {
+		opts.JSON = true
+	}
+
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+
+	w := &Watcher{opts: opts, fatal: make(chan error, 1), failed: make(chan *run, 1)}
+
+	if opts.IgnoreOlderThan != 0 {
+		w.cutoff = time.Now().Add(-opts.IgnoreOlderThan)
+	}
+
+	if err := w.normalizePaths(); err != nil {
+		return nil, err
+	}
+
+	if err := w.readIgnoreFiles(); err != nil {
+		return nil, err
+	}
+
+	if opts.JSON {
+		w.encoder = newEventEncoder(opts.Stdout, opts.Name)
+	}
+
+	return w, nil
+}
+
+// Run executes the commands once and then again whenever a change is detected,
+// until ctx is done or an error occurs. Once ctx is done, the command running,
+// if any, is terminated. Errors that stop Run are also reported to the Stderr
+// of the options, or to Stdout in JSON mode. If the Once or Count options are
+// set, Run returns the error of the commands run for the last change, such as
+// an *exec.ExitError, as it does for their first failure with FailFast.
+//
+// Changes are detected against the files as they were before the first
+// execution, so that changes made while it runs, which it may have missed,
+// cause one more execution once it is done, however many they are, unless
+// the IgnoreSelf option is set.
+func (w *Watcher) Run(ctx context.Context) error {
+	if len(w.opts.Exec) == 0 && w.opts.Run == nil && w.opts.OnChange == nil {
+		return w.fail(ErrNoCommand)
+	}
+
+	w.stats.since = time.Now()
+	defer w.summarize()
+
+	defer w.stop()
+
+	current, err := w.takeSnapshot()
+	if err != nil {
+		return w.fail(err)
+	}
+
+	// handled is the snapshot the commands have last been run for, which is
+	// saved to the state file once Run returns
+	handled := current
+
+	// the notifier is started beforehand, so that it is already listening
+	// while the first execution runs
+	var events <-chan struct{}
+	if w.opts.Notify {
+		if err := w.startNotifier(current); err != nil {
+			return w.fail(err)
+		}
+
+		if w.notifier != nil {
+			defer func() {
+				if w.notifier != nil {
+					w.notifier.close()
+				}
+			}()
+
+			events = w.notifier.events()
+		}
+	}
+
+	// the file is tailed from before the first execution, so that nothing
+	// appended while it runs is missed
+	var tail <-chan []byte
+	if w.opts.Tail != "" {
+		tail = w.readTail(ctx)
+	}
+
+	// settle takes the files as the commands have left them as the ones to
+	// detect changes against, if they are to be ignored
+	settle := func() error {
+		if !w.opts.IgnoreSelf {
+			return nil
+		}
+
+		next, err := w.takeSnapshot()
+		if err != nil {
+			return w.fail(err)
+		}
+
+		if ch, changed := next.compare(current); changed {
+			w.debug("ignoring the changes made while running: %s", strings.Join(ch.files, ", "))
+		}
+
+		current = next
+		handled = current
+		return nil
+	}
+
+	// the changes made while the watcher was not running, if known, take the
+	// place of the first execution
+	initial, runs := change{}, w.runsInitially()
+	if w.opts.StateFile != "" {
+		defer func() { w.saveState(handled) }()
+
+		if prev, ok := w.loadState(current); ok {
+			if initial, runs = current.compare(prev); runs {
+				w.debug("detected since the last run: %s", initial)
+			}
+		}
+	}
+
+	if runs {
+		if w.opts.StartDelay != 0 {
+			w.debug("waiting %s before the first execution", w.opts.StartDelay)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(w.opts.StartDelay):
+			}
+		}
+
+		if initial.kind != changeNone && w.final() || w.opts.CountInitial && (w.opts.Once || w.opts.Count == 1) {
+			return w.executeOnce(ctx, initial)
+		}
+
+		w.ranInitially = initial.kind == changeNone
+
+		if err := w.executeAndHandle(ctx, initial); err != nil {
+			return err
+		}
+
+		if err := settle(); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(w.opts.TickSpeed)
+	defer ticker.Stop()
+
+	var triggers <-chan string
+	if w.opts.TriggerStdin {
+		triggers = w.readTriggers(ctx)
+	}
+
+	var keys <-chan byte
+	if w.opts.Interactive {
+		keys = w.readKeys(ctx)
+	}
+
+	paused := false
+
+	// the changes pending are run once this fires, when debouncing them or
+	// batching them over a window
+	delay := time.NewTimer(w.opts.Debounce)
+	delay.Stop()
+
+	retry := time.NewTimer(RetryBackoff)
+	retry.Stop()
+
+	// the preview counts down to when the delay fires
+	countdown := time.NewTicker(Granularity)
+	countdown.Stop()
+
+	var deadline time.Time
+
+	var pending change
+	var failed *run
+
+	for {
+		var ch change
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err := <-w.fatal:
+			return err
+
+		case <-delay.C:
+			ch, pending = pending, change{}
+
+			countdown.Stop()
+			w.endPreview()
+
+			handled = current
+			if w.final() {
+				return w.executeOnce(ctx, ch)
+			}
+
+			if err := w.executeAndHandle(ctx, ch); err != nil {
+				return err
+			}
+
+			if err := settle(); err != nil {
+				return err
+			}
+			continue
+
+		case <-countdown.C:
+			w.preview(pending, deadline)
+			continue
+
+		case r := <-w.failed:
+			// failures of runs superseded by a change are left alone
+			if r != w.latest || r.attempt >= w.opts.Retry {
+				continue
+			}
+
+			failed = r
+			retry.Reset(backoff(r.attempt + 1))
+			continue
+
+		case <-retry.C:
+			if err := w.executeRun(ctx, failed.retry()); err != nil {
+				return err
+			}
+
+			if err := settle(); err != nil {
+				return err
+			}
+			continue
+
+		case line, ok := <-triggers:
+			if !ok {
+				triggers = nil
+				continue
+			}
+
+			ch = change{kind: changeTriggered, path: line}
+
+		case data, ok := <-tail:
+			if !ok {
+				tail = nil
+				continue
+			}
+
+			ch = change{kind: changeAppended, path: w.opts.Tail, files: []string{w.opts.Tail}, appended: data}
+
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			switch key {
+			case ' ':
+				paused = !paused
+				w.pause(paused)
+				continue
+
+			case 'r':
+				ch = change{kind: changeTriggered}
+
+			default:
+				continue
+			}
+
+		case <-events:
+
+		case <-ticker.C:
+			if w.notifier != nil && !w.polled && !w.isMissingRoots(current) {
+				w.heartbeat()
+				continue
+			}
+		}
+
+		// changes made while paused are detected once resumed
+		if paused && ch.kind == changeNone {
+			continue
+		}
+
+		if ch.kind == changeNone {
+			// a snapshot is only taken once something is known to have
+			// changed, to tell what has
+			if !w.opts.Hash {
+				changed, err := w.changed(current)
+				if err != nil {
+					return w.fail(err)
+				}
+
+				if !changed {
+					w.heartbeat()
+					continue
+				}
+			}
+
+			next, err := w.takeSnapshot()
+			if err != nil {
+				return w.fail(err)
+			}
+
+			if w.notifier != nil {
+				if err := w.syncNotifier(next); err != nil {
+					return w.fail(err)
+				}
+
+				if w.notifier == nil {
+					events = nil
+				}
+			}
+
+			var changed bool
+			if ch, changed = next.compare(current); !changed {
+				w.heartbeat()
+				continue
+			}
+
+			w.debug("detected: %s", ch)
+
+			current = next
+		}
+
+		// a new change supersedes the retries of the previous one
+		retry.Stop()
+
+		// the debounce restarts on every change, while the batch window only
+		// starts on the first one, and the throttle holds changes back until
+		// it has elapsed since the last run
+		held := w.opts.Debounce != 0 || w.opts.BatchWindow != 0 ||
+			w.opts.Throttle != 0 && pending.kind != changeNone
+
+		switch {
+		case w.opts.Debounce != 0:
+			delay.Reset(w.opts.Debounce)
+			deadline = time.Now().Add(w.opts.Debounce)
+		case w.opts.BatchWindow != 0 && pending.kind == changeNone:
+			delay.Reset(w.opts.BatchWindow)
+			deadline = time.Now().Add(w.opts.BatchWindow)
+		case w.opts.Throttle != 0 && pending.kind == changeNone &&
+			w.latest != nil && time.Since(w.latest.time) < w.opts.Throttle:
+			deadline = w.latest.time.Add(w.opts.Throttle)
+			delay.Reset(time.Until(deadline))
+			held = true
+		}
+
+		if held {
+			pending = pending.merge(ch)
+
+			if w.opts.Preview {
+				countdown.Reset(Granularity)
+				w.preview(pending, deadline)
+			}
+			continue
+		}
+
+		handled = current
+		if w.final() {
+			return w.executeOnce(ctx, ch)
+		}
+
+		if err := w.executeAndHandle(ctx, ch); err != nil {
+			return err
+		}
+
+		if err := settle(); err != nil {
+			return err
+		}
+	}
+}
+
+// readTriggers sends every line read from Stdin until ctx is done, closing the
+// channel once there is nothing left to read.
+func (w *Watcher) readTriggers(ctx context.Context) <-chan string {
+	triggers := make(chan string)
+
+	go func() {
+		defer close(triggers)
+
+		scanner := bufio.NewScanner(w.opts.Stdin)
+		for scanner.Scan() {
+			select {
+			case triggers <- strings.TrimSpace(scanner.Text()):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return triggers
+}
+
+// readKeys sends every byte read from Stdin until ctx is done, closing the
+// channel once there is nothing left to read.
+func (w *Watcher) readKeys(ctx context.Context) <-chan byte {
+	keys := make(chan byte)
+
+	go func() {
+		defer close(keys)
+
+		buf := make([]byte, 1)
+		for {
+			if _, err := w.opts.Stdin.Read(buf); err != nil {
+				return
+			}
+
+			select {
+			case keys <- buf[0]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return keys
+}
+
+// final tells whether the commands are about to run for the last time, after
+// which Run returns, as for Once or Count.
+func (w *Watcher) final() bool {
+	if w.opts.Once {
+		return true
+	}
+
+	if w.opts.Count == 0 {
+		return false
+	}
+
+	// the run about to start is counted too
+	runs := w.changes + 1
+	if w.opts.CountInitial && w.ranInitially {
+		runs++
+	}
+
+	return runs >= w.opts.Count
+}
+
+// runsInitially reports whether the commands are run before any change is
+// detected, which is the default.
+func (w *Watcher) runsInitially() bool {
+	if w.opts.NoInitial {
+		return false
+	}
+
+	return !w.opts.RequireChange || !w.usesPlaceholder()
+}
+
+// resolve takes path from base if it is relative, so that the empty path
+// stands for base. Paths are left as they are if there is no base.
+func resolve(base, path string) string {
+	if base == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(base, path)
+}
+
+// normalizePaths makes the watch roots absolute, expanding the ones that are
+// patterns into the paths they match when the watcher is created. Patterns
+// matching nothing are kept as they are.
+func (w *Watcher) normalizePaths() error {
+	var roots []string
+
+	for _, root := range w.opts.Watch {
+		result, err := filepath.Abs(resolve(w.opts.Root, root))
+		if err != nil {
+			return err
+		}
+
+		if !hasMeta(filepath.ToSlash(result)) {
+			roots = append(roots, result)
+			continue
+		}
+
+		matches, err := glob(result)
+		if err != nil {
+			return err
+		}
+
+		if len(matches) == 0 {
+			roots = append(roots, result)
+			continue
+		}
+
+		roots = append(roots, matches...)
+	}
+
+	w.opts.Watch = dedupeRoots(roots)
+
+	if !w.opts.AllowMissing {
+		var missing []string
+		for _, root := range w.opts.Watch {
+			if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+				missing = append(missing, root)
+			}
+		}
+
+		if len(missing) > 0 {
+			return errMissingPaths(missing)
+		}
+	}
+
+	for i := range len(w.opts.Ignore) {
+		w.opts.Ignore[i] = filepath.Clean(w.opts.Ignore[i])
+
+		if !filepath.IsAbs(w.opts.Ignore[i]) {
+			w.opts.Ignore[i] = filepath.ToSlash(w.opts.Ignore[i])
+		}
+
+		if err := validatePattern(filepath.ToSlash(w.opts.Ignore[i])); err != nil {
+			return err
+		}
+	}
+
+	for i := range len(w.opts.Include) {
+		pattern, negate := strings.CutPrefix(w.opts.Include[i], "!")
+		pattern = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(pattern)), "/")
+
+		if err := validatePattern(pattern); err != nil {
+			return err
+		}
+
+		if negate {
+			pattern = "!" + pattern
+		}
+
+		w.opts.Include[i] = pattern
+	}
+
+	return nil
+}
+
+// isMissingRoots reports whether any of the roots is missing from snap, in
+// which case there are no notifications for it to come by.
+func (w *Watcher) isMissingRoots(snap snapshot) bool {
+	for _, root := range w.opts.Watch {
+		if _, ok := snap.files[root]; !ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dedupeRoots removes the roots that are the same as, or inside of, another
+// root, as they would be walked over twice otherwise. The roots must be
+// absolute.
+func dedupeRoots(roots []string) []string {
+	var result []string
+
+	for i, root := range roots {
+		covered := false
+		for j, other := range roots {
+			if i == j || !isWithin(other, root) {
+				continue
+			}
+
+			// of the roots that are the same, the first one is kept
+			if other != root || j < i {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			result = append(result, root)
+		}
+	}
+
+	return result
+}
+
+// isWithin reports whether name is parent or a path inside of it.
+func isWithin(parent, name string) bool {
+	rel, err := filepath.Rel(parent, name)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Options returns the options as New has resolved them, with the paths made
+// absolute, the patterns expanded and the defaults applied, the shell the
+// commands are run through included.
+func (w *Watcher) Options() Options {
+	opts := w.opts
+	opts.Watch = slices.Clone(opts.Watch)
+	opts.Ignore = slices.Clone(opts.Ignore)
+	opts.IgnoreFiles = slices.Clone(opts.IgnoreFiles)
+	opts.Extensions = slices.Clone(opts.Extensions)
+	opts.Include = slices.Clone(opts.Include)
+	opts.Env = slices.Clone(opts.Env)
+
+	opts.Exec = make([][]string, len(w.opts.Exec))
+	for i, cmd := range w.opts.Exec {
+		opts.Exec[i] = slices.Clone(cmd)
+	}
+
+	if !opts.NoShell {
+		if shell, _, err := w.shell(); err == nil {
+			opts.Shell = shell
+		}
+	}
+
+	return opts
+}
+
+// List returns the paths being watched over, sorted, as the first scan of Run
+// would find them, leaving out the ignored paths and the files filtered out.
+// Directories are left out as well when files are filtered.
+func (w *Watcher) List() ([]string, error) {
+	snap, err := w.takeSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(snap.files))
+	for path, st := range snap.files {
+		if !st.isDir || !snap.filesOnly {
+			paths = append(paths, path)
+		}
+	}
+
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// startNotifier sets up the notifier and has it watch the directories in
+// snap, leaving it nil if polling is fallen back to.
+func (w *Watcher) startNotifier(snap snapshot) error {
+	ntf, err := newNotifier()
+	if err != nil {
+		if w.opts.NoPollFallback {
+			return err
+		}
+
+		w.warn("falling back to polling: " + err.Error())
+		return nil
+	}
+
+	w.notifier = ntf
+	return w.syncNotifier(snap)
+}
+
+// syncNotifier has the notifier watch the directories in snap. Running out of
+// watches keeps it, the directories left unwatched being polled until they
+// can be watched, while any other error falls back to polling entirely. The
+// errors are only returned if NoPollFallback is set.
+func (w *Watcher) syncNotifier(snap snapshot) error {
+	err := w.notifier.sync(w.opts.Watch, snap)
+	if err == nil {
+		if w.polled {
+			w.debug("every directory is being watched again, polling has stopped")
+			w.polled = false
+		}
+
+		return nil
+	}
+
+	if w.opts.NoPollFallback {
+		return err
+	}
+
+	var limit *watchLimitError
+	if errors.As(err, &limit) {
+		if !w.polled {
+			w.warn("polling the directories left unwatched: " + err.Error())
+			w.polled = true
+		}
+
+		return nil
+	}
+
+	w.warn("falling back to polling: " + err.Error())
+
+	w.notifier.close()
+	w.notifier, w.polled = nil, false
+	return nil
+}
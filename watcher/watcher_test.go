@@ -0,0 +1,197 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer that may be written to by the commands and
+// the watcher from several goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// writeTree creates the files under dir, each of them holding its own name,
+// along with the directories they are in. Names ending in a slash are created
+// as empty directories.
+func writeTree(t testing.TB, dir string, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeSyntheticTree creates dirs directories under dir, each holding files
+// files of size bytes, to be walked over by the benchmarks.
+func writeSyntheticTree(tb testing.TB, dir string, dirs, files, size int) {
+	tb.Helper()
+
+	data := bytes.Repeat([]byte("x"), size)
+	for i := range dirs {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%03d", i))
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			tb.Fatal(err)
+		}
+
+		for j := range files {
+			if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("file%03d.txt", j)), data, 0o644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+}
+
+// newTestWatcher creates a Watcher from opts, watching over a new temporary
+// directory if no path is given, its output and that of the commands going to
+// the buffer returned.
+func newTestWatcher(t testing.TB, opts Options) (*Watcher, *lockedBuffer) {
+	t.Helper()
+
+	if len(opts.Watch) == 0 && opts.Tail == "" {
+		opts.Watch = []string{t.TempDir()}
+	}
+
+	out := &lockedBuffer{}
+	if opts.Stdout == nil {
+		opts.Stdout = out
+	}
+
+	if opts.Stderr == nil {
+		opts.Stderr = out
+	}
+
+	opts.NoHeartbeat = true
+	opts.NoClear = true
+
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w, out
+}
+
+// runOnce runs the commands of w a single time, as the first execution, and
+// returns what Run has returned.
+func runOnce(t *testing.T, w *Watcher) error {
+	t.Helper()
+
+	w.opts.Once, w.opts.CountInitial = true, true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return w.Run(ctx)
+}
+
+// requireSh skips the test on Windows, where the commands are not run through
+// sh, as those of the test are written for it.
+func requireSh(t *testing.T) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are written for sh")
+	}
+}
+
+// waitFor waits for s to be written to out.
+func waitFor(t *testing.T, out *lockedBuffer, s string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), s) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%q has not been written, only: %q", s, out)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// runAsync runs w until the context is canceled at the end of the test,
+// returning the channel Run returns on.
+func runAsync(t *testing.T, w *Watcher) <-chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result, done := make(chan error, 1), make(chan struct{})
+	go func() {
+		defer close(done)
+		result <- w.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return result
+}
+
+func TestRootResolvesPaths(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	writeTree(t, dir, "src/a.go", "out/")
+
+	tests := []struct {
+		watch, dir string
+		wantWatch  string
+		wantDir    string
+	}{
+		{"src", "", filepath.Join(dir, "src"), dir},
+		{"src", "out", filepath.Join(dir, "src"), filepath.Join(dir, "out")},
+		{other, other, other, other},
+	}
+
+	for _, tt := range tests {
+		w, _ := newTestWatcher(t, Options{Root: dir, Watch: []string{tt.watch}, Dir: tt.dir})
+
+		if got := w.opts.Watch; len(got) != 1 || got[0] != tt.wantWatch {
+			t.Errorf("%s: got %q watched over, want %q", tt.watch, got, tt.wantWatch)
+		}
+
+		if w.opts.Dir != tt.wantDir {
+			t.Errorf("%s: got %q as the directory, want %q", tt.dir, w.opts.Dir, tt.wantDir)
+		}
+	}
+
+	if _, err := New(Options{Root: filepath.Join(dir, "src", "a.go"), Watch: []string{"."}}); err == nil {
+		t.Error("got no error for a root that is a file")
+	}
+}